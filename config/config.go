@@ -0,0 +1,74 @@
+// Package config layers the settings of the server and the client: built-in
+// defaults, then a TOML file, then environment variables, then flags given
+// on the command line.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FileFlag is the flag (and, with the prefix, the environment variable) that
+// names the TOML file.
+const FileFlag = "config"
+
+// Load parses args with fs and fills target. The flags of fs must be bound to
+// the fields of target, which holds the defaults when Load is called. Every
+// flag can also be set through the environment as PREFIX_NAME, with dashes
+// turned into underscores.
+func Load(fs *flag.FlagSet, args []string, envPrefix string, target any) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	path := os.Getenv(EnvName(envPrefix, FileFlag))
+	if f := fs.Lookup(FileFlag); f != nil && f.Value.String() != "" {
+		path = f.Value.String()
+	}
+	if path != "" {
+		md, err := toml.DecodeFile(path, target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == FileFlag {
+			return
+		}
+		name := EnvName(envPrefix, f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: %w", name, value, err))
+			}
+		}
+	})
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EnvName is the environment variable that overrides flag.
+func EnvName(prefix, flag string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
@@ -7,6 +7,12 @@ require (
 	github.com/chromedp/chromedp v0.9.5
 )
 
+require golang.org/x/crypto v0.24.0
+
+require github.com/BurntSushi/toml v1.4.0
+
+require golang.org/x/term v0.21.0
+
 require (
 	github.com/andybalholm/cascadia v1.3.2 // indirect
 	github.com/chromedp/cdproto v0.0.0-20240202021202-6d0b6a386732 // indirect
@@ -14,7 +20,7 @@ require (
 	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.3.2 // indirect
+	github.com/gobwas/ws v1.3.2
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	golang.org/x/net v0.24.0 // indirect
@@ -2,97 +2,457 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/eiannone/keyboard"
+	"golang.org/x/term"
+
+	"pokeGame/config"
+	"pokeGame/protocol"
 )
 
+const reconnectDelay = 2 * time.Second
+
+// errBanned ends the login of a banned account; the server's message has
+// been shown already.
+var errBanned = errors.New("banned")
+
 var (
 	consoleLock sync.Mutex
 )
 
-func onMessage(conn net.Conn) {
-	reader := bufio.NewReader(conn)
+// keyDirections maps the arrow keys used in catch mode to moves.
+var keyDirections = map[keyboard.Key]string{
+	keyboard.KeyArrowUp:    protocol.DirectionUp,
+	keyboard.KeyArrowDown:  protocol.DirectionDown,
+	keyboard.KeyArrowLeft:  protocol.DirectionLeft,
+	keyboard.KeyArrowRight: protocol.DirectionRight,
+	keyboard.KeyEsc:        protocol.DirectionQuit,
+}
+
+// client keeps the connection to the server and replaces it with a resumed
+// one when it breaks.
+type client struct {
+	addr  string
+	tls   *tls.Config // nil for plain TCP
+	mu    sync.Mutex
+	conn  *protocol.Conn
+	name  string
+	token string
+	done  bool          // the server said goodbye
+	view  protocol.View // catch mode, only touched by onMessage
+}
+
+func (c *client) current() *protocol.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *client) send(t protocol.Type, payload any) error {
+	return c.current().Send(t, payload)
+}
+
+func (c *client) dial() (*protocol.Conn, error) {
+	var connection net.Conn
+	var err error
+	if c.tls != nil {
+		connection, err = tls.Dial("tcp", c.addr, c.tls)
+	} else {
+		connection, err = net.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := protocol.NewConn(connection)
+	if _, err := conn.ClientHandshake("pokeGame player"); err != nil {
+		connection.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// login asks for the password and logs in, offering to register unknown
+// names. It reports whether the server resumed a dropped session instead.
+func (c *client) login(stdin *bufio.Reader) (bool, error) {
+	ask := func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		line, err := stdin.ReadString('\n')
+		return strings.TrimSpace(line), err
+	}
+	// passwords are not echoed when typed on a terminal
+	askPassword := func(prompt string) (string, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return ask(prompt)
+		}
+		fmt.Print(prompt)
+		password, err := term.ReadPassword(fd)
+		fmt.Println()
+		return strings.TrimSpace(string(password)), err
+	}
+	password, err := askPassword("Password: ")
+	if err != nil {
+		return false, err
+	}
+	msgType, payload := protocol.TypeLogin, any(protocol.Login{Name: c.name, Password: password})
 	for {
-		msg, _ := reader.ReadString('#')
+		if err := c.send(msgType, payload); err != nil {
+			return false, err
+		}
+		env, err := c.current().Receive()
+		if err != nil {
+			return false, err
+		}
+		if env.Type == protocol.TypeLoginOK {
+			var ok protocol.LoginOK
+			if err := env.Decode(&ok); err != nil {
+				return false, err
+			}
+			c.name, c.token = ok.Name, ok.ResumeToken
+			fmt.Printf("Welcome, %s!\n", ok.Name)
+			return ok.Resumed, nil
+		}
+		fmt.Print(render(env))
+		var e protocol.Error
+		if env.Type != protocol.TypeError || env.Decode(&e) != nil {
+			continue
+		}
+		switch e.Code {
+		case protocol.ErrUnknownAccount:
+			answer, err := ask("Create the account? (y/n): ")
+			if err != nil {
+				return false, err
+			}
+			if strings.EqualFold(answer, "y") {
+				confirm, err := askPassword("Repeat the password: ")
+				if err != nil {
+					return false, err
+				}
+				if confirm == password {
+					msgType, payload = protocol.TypeRegister, protocol.Register{Name: c.name, Password: password}
+					continue
+				}
+				fmt.Println("The passwords do not match.")
+			}
+			if c.name, err = ask("Your name: "); err != nil {
+				return false, err
+			}
+			if password, err = askPassword("Password: "); err != nil {
+				return false, err
+			}
+		case protocol.ErrBadCredentials, protocol.ErrBadRequest:
+			if password, err = askPassword("Password: "); err != nil {
+				return false, err
+			}
+		case protocol.ErrNameTaken, protocol.ErrAlreadyOnline:
+			if c.name, err = ask("Your name: "); err != nil {
+				return false, err
+			}
+			if password, err = askPassword("Password: "); err != nil {
+				return false, err
+			}
+		case protocol.ErrTooManyAttempts:
+			time.Sleep(reconnectDelay)
+			continue
+		case protocol.ErrBanned:
+			return false, errBanned
+		}
+		msgType, payload = protocol.TypeLogin, protocol.Login{Name: c.name, Password: password}
+	}
+}
+
+// reconnect dials again and resumes the session with the token until the
+// server accepts or refuses it.
+func (c *client) reconnect() error {
+	for {
+		time.Sleep(reconnectDelay)
+		conn, err := c.dial()
+		if err != nil {
+			continue
+		}
+		if err := conn.Send(protocol.TypeLogin, protocol.Login{Name: c.name, ResumeToken: c.token}); err != nil {
+			continue
+		}
+		env, err := conn.Receive()
+		if err != nil {
+			continue
+		}
+		if env.Type != protocol.TypeLoginOK {
+			return errors.New(strings.TrimSpace(render(env)))
+		}
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		return nil
+	}
+}
+
+func (c *client) onMessage() {
+	for {
+		env, err := c.current().Receive()
+		if err != nil {
+			if c.done {
+				fmt.Println("\nConnection closed")
+				os.Exit(0)
+			}
+			consoleLock.Lock()
+			fmt.Println("\nConnection lost, reconnecting...")
+			consoleLock.Unlock()
+			if err := c.reconnect(); err != nil {
+				fmt.Println("Could not resume the game:", err)
+				os.Exit(0)
+			}
+			continue
+		}
+		if env.Type == protocol.TypeBye {
+			c.done = true
+		}
+		text := c.renderView(env)
+		if text == "" {
+			text = render(env)
+		}
 
 		consoleLock.Lock()
-		fmt.Print(msg[:len(msg)-1])
+		fmt.Print(text)
 		consoleLock.Unlock()
 	}
 }
 
+// typeKey adds a key pressed in catch mode to line and sends the line on
+// Enter. It reports whether the key was typing rather than a move.
+func (c *client) typeKey(line *[]rune, event keyboard.KeyEvent) bool {
+	consoleLock.Lock()
+	defer consoleLock.Unlock()
+	switch {
+	case event.Key == keyboard.KeyEnter:
+		text := strings.TrimSpace(string(*line))
+		*line = nil
+		fmt.Println()
+		var err error
+		if chat, ok := strings.CutPrefix(text, "/chat "); ok {
+			err = c.send(protocol.TypeChat, protocol.Chat{Text: chat})
+		} else if text != "" {
+			err = c.send(protocol.TypeInput, protocol.Input{Text: text})
+		}
+		if err != nil {
+			fmt.Println("Not connected, please try again in a moment")
+		}
+	case event.Key == keyboard.KeyBackspace || event.Key == keyboard.KeyBackspace2:
+		if len(*line) > 0 {
+			*line = (*line)[:len(*line)-1]
+			fmt.Print("\b \b")
+		}
+	case event.Key == keyboard.KeySpace:
+		*line = append(*line, ' ')
+		fmt.Print(" ")
+	case event.Rune != 0:
+		*line = append(*line, event.Rune)
+		fmt.Print(string(event.Rune))
+	default:
+		return false
+	}
+	return true
+}
+
+// renderView keeps the catch-mode view current and redraws it; frames that
+// are not about the world render as "".
+func (c *client) renderView(env protocol.Envelope) string {
+	switch env.Type {
+	case protocol.TypeViewport:
+		var vp protocol.Viewport
+		if env.Decode(&vp) != nil {
+			return ""
+		}
+		c.view.Reset(vp)
+	case protocol.TypeWorldDelta:
+		var delta protocol.WorldDelta
+		if env.Decode(&delta) != nil {
+			return ""
+		}
+		c.view.Apply(delta)
+	default:
+		return ""
+	}
+	return c.view.Render()
+}
+
+// render turns a frame from the server into console output.
+func render(env protocol.Envelope) string {
+	switch env.Type {
+	case protocol.TypeNotice:
+		var n protocol.Notice
+		if env.Decode(&n) == nil {
+			return n.Text
+		}
+	case protocol.TypeMapUpdate:
+		var m protocol.MapUpdate
+		if env.Decode(&m) == nil {
+			return m.Map
+		}
+	case protocol.TypeBattlePrompt:
+		var p protocol.BattlePrompt
+		if env.Decode(&p) == nil {
+			return p.Text
+		}
+	case protocol.TypeBattleEvent:
+		var e protocol.BattleEvent
+		if env.Decode(&e) == nil {
+			return e.Text
+		}
+	case protocol.TypeChat:
+		var c protocol.Chat
+		if env.Decode(&c) == nil {
+			return fmt.Sprintf("\n💬 %s: %s\n", c.From, c.Text)
+		}
+	case protocol.TypeBye:
+		var b protocol.Bye
+		if env.Decode(&b) == nil && b.Reason != "" {
+			return fmt.Sprintf("\n👋 %s\n", b.Reason)
+		}
+	case protocol.TypeLoginOK:
+		return "🔌 Reconnected\n"
+	case protocol.TypeError:
+		var e protocol.Error
+		if env.Decode(&e) == nil {
+			return fmt.Sprintf("⚠️ %s\n", e.Message)
+		}
+	}
+	return ""
+}
+
+// envPrefix names the environment variables of the client, like
+// POKEGAME_CLIENT_ADDR.
+const envPrefix = "POKEGAME_CLIENT"
+
+// clientConfig is what the client reads from -config, the environment and
+// its flags.
+type clientConfig struct {
+	Addr string `toml:"addr"`
+	TLS  bool   `toml:"tls"`
+	CA   string `toml:"ca"`
+	Pin  string `toml:"pin"`
+}
+
 func main() {
-	connection, err := net.Dial("tcp", "localhost:3015")
+	settings := clientConfig{Addr: "localhost:3015"}
+	flag.String(config.FileFlag, "", "TOML file with the client settings (env "+config.EnvName(envPrefix, config.FileFlag)+")")
+	flag.StringVar(&settings.Addr, "addr", settings.Addr, "server address")
+	flag.BoolVar(&settings.TLS, "tls", settings.TLS, "connect over TLS")
+	flag.StringVar(&settings.CA, "ca", settings.CA, "PEM file with the CA (or self-signed server certificate) to trust")
+	flag.StringVar(&settings.Pin, "pin", settings.Pin, "SHA-256 fingerprint of the server certificate to accept")
+	if err := config.Load(flag.CommandLine, os.Args[1:], envPrefix, &settings); err != nil {
+		log.Fatal(err)
+	}
+
+	c := &client{addr: settings.Addr}
+	if settings.TLS || settings.CA != "" || settings.Pin != "" {
+		tlsSettings, err := tlsConfig(settings.Addr, settings.CA, settings.Pin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.tls = tlsSettings
+	}
+	conn, err := c.dial()
 	if err != nil {
+		var protoErr protocol.Error
+		if errors.As(err, &protoErr) {
+			log.Fatalf("server refused the connection: %s", protoErr.Message)
+		}
 		log.Fatal(err)
 	}
+	c.conn = conn
 
 	fmt.Print("MODE: 1. POKEBAT \t 2. POKECAT\nType following syntax: [Name] [Mode]\nYour Input: ")
-	nameReader := bufio.NewReader(os.Stdin)
-	input, _ := nameReader.ReadString('\n')
-
-	connection.Write([]byte(input))
+	stdin := bufio.NewReader(os.Stdin)
+	var mode string
+	for {
+		input, err := stdin.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(input)
+		if len(fields) == 2 && (fields[1] == "1" || fields[1] == "2") {
+			c.name, mode = fields[0], fields[1]
+			break
+		}
+		fmt.Print("Please type your name and 1 or 2: ")
+	}
+	resumed, err := c.login(stdin)
+	if errors.Is(err, errBanned) {
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	selected := protocol.ModeBattle
+	if mode == "2" {
+		selected = protocol.ModeCatch
+	}
+	if resumed {
+		fmt.Println("Your previous game is still running, picking it up.")
+	} else if err := c.send(protocol.TypeModeSelect, protocol.ModeSelect{Mode: selected}); err != nil {
+		log.Fatal(err)
+	}
 	fmt.Println("********** Entered Game **********")
 
-	go onMessage(connection)
+	go c.onMessage()
 
-	// separate the name and mode
-	input = strings.TrimSpace(input)
-	// playerName := strings.Split(input, " ")[0]
-	mode := strings.Split(input, " ")[1]
 	if mode == "1" {
 		for {
-			msgReader := bufio.NewReader(os.Stdin)
-
-			msg, err := msgReader.ReadString('\n')
-			// Remove any CR characters from the input
-			msg = strings.Replace(msg, "\r", "", -1)
+			msg, err := stdin.ReadString('\n')
 			if err != nil {
 				break
 			}
-
-			consoleLock.Lock()
-			_, err = connection.Write([]byte(msg))
+			// Remove any CR characters from the input
+			msg = strings.TrimRight(msg, "\r\n")
+			// lines starting with /chat go to everybody
+			if text, ok := strings.CutPrefix(msg, "/chat "); ok {
+				err = c.send(protocol.TypeChat, protocol.Chat{Text: text})
+			} else {
+				err = c.send(protocol.TypeInput, protocol.Input{Text: msg})
+			}
 			if err != nil {
-				fmt.Println("Connection closed")
-				break
+				fmt.Println("Not connected, please try again in a moment")
 			}
-			consoleLock.Unlock()
 		}
 	} else if mode == "2" {
-		// go onKeyInput(connection, inputCh)
-
+		keysEvents, err := keyboard.GetKeys(10)
+		if err != nil {
+			panic(err)
+		}
+		defer keyboard.Close()
+		// what is typed is sent as a line on Enter, to answer the prompts
+		// of a wild battle or to chat
+		var line []rune
 		for {
-			keysEvents, err := keyboard.GetKeys(10)
-			if err != nil {
-				panic(err)
+			event := <-keysEvents
+			if event.Err != nil {
+				panic(event.Err)
 			}
-			for {
-				event := <-keysEvents
-				if event.Err != nil {
-					panic(event.Err)
-				}
-				// Send the key character to the input channel
-				msg := string(rune(event.Key))
-				consoleLock.Lock()
-				_, err := connection.Write([]byte(msg + "\n"))
-				consoleLock.Unlock()
-				// fmt.Println("Sent key press event to server: ", string(event.Key))
-				if err != nil {
-					fmt.Println("Failed to send key press event to server:", err)
-					return
-				}
-				// if event.Key == keyboard.KeyEsc {
-
-				// }
+			if c.typeKey(&line, event) {
+				continue
+			}
+			direction, ok := keyDirections[event.Key]
+			if !ok {
+				continue
+			}
+			if err := c.send(protocol.TypeMove, protocol.Move{Direction: direction}); err != nil {
+				fmt.Println("Not connected, please try again in a moment")
+				continue
+			}
+			if direction == protocol.DirectionQuit {
+				return
 			}
 		}
 	}
-	// connection.Close()
 }
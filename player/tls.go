@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// tlsConfig builds the client side of the TLS connection. With a pin only
+// the server certificate whose SHA-256 fingerprint matches is accepted, so a
+// self-signed server certificate works without a CA. With a CA file the
+// server certificate must chain to one of its certificates. Without either
+// the system roots are used.
+func tlsConfig(addr, caFile, pin string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if pin != "" {
+		want, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(pin), "sha256:"), ":", ""))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("the pin must be a hex SHA-256 fingerprint")
+		}
+		// the chain is not checked, the pin is
+		config.InsecureSkipVerify = caFile == ""
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("the server sent no certificate")
+			}
+			got := sha256.Sum256(rawCerts[0])
+			if subtle.ConstantTimeCompare(got[:], want) != 1 {
+				return fmt.Errorf("the server certificate %s does not match the pin", hex.EncodeToString(got[:]))
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
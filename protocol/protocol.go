@@ -0,0 +1,398 @@
+// Package protocol holds the messages shared by the game server and the
+// player client and the framing used to send them: every frame is a 4-byte
+// big-endian length followed by a JSON envelope.
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Version is the newest protocol version this package speaks. Version 2
+// replaced the catch-mode MapUpdate with Viewport and WorldDelta.
+const Version = 2
+
+// MaxFrameSize bounds a single frame so a broken peer cannot make us
+// allocate arbitrary amounts of memory.
+const MaxFrameSize = 1 << 20
+
+// Type names the payload carried by an envelope.
+type Type string
+
+const (
+	TypeHello        Type = "hello"         // client -> server, opens the handshake
+	TypeWelcome      Type = "welcome"       // server -> client, handshake accepted
+	TypeLogin        Type = "login"         // client -> server
+	TypeRegister     Type = "register"      // client -> server, creates an account and logs in
+	TypeLoginOK      Type = "login_ok"      // server -> client, carries the resume token
+	TypeModeSelect   Type = "mode_select"   // client -> server
+	TypeNotice       Type = "notice"        // server -> client, plain information
+	TypeMapUpdate    Type = "map_update"    // server -> client, catch mode, version 1
+	TypeViewport     Type = "viewport"      // server -> client, catch mode
+	TypeWorldDelta   Type = "world_delta"   // server -> client, catch mode
+	TypeMove         Type = "move"          // client -> server, catch mode
+	TypeBattlePrompt Type = "battle_prompt" // server -> client, waits for an Input
+	TypeBattleEvent  Type = "battle_event"  // server -> client
+	TypeInput        Type = "input"         // client -> server, battle answers and /commands
+	TypeChat         Type = "chat"          // both directions
+	TypeError        Type = "error"         // server -> client
+	TypeBye          Type = "bye"           // server -> client, the server closes the session
+)
+
+// Modes accepted in ModeSelect.
+const (
+	ModeBattle = "battle"
+	ModeCatch  = "catch"
+)
+
+// Directions accepted in Move.
+const (
+	DirectionUp    = "up"
+	DirectionDown  = "down"
+	DirectionLeft  = "left"
+	DirectionRight = "right"
+	DirectionQuit  = "quit"
+)
+
+// Error codes sent in Error.
+const (
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrBadRequest         = "bad_request"
+	ErrNameTaken          = "name_taken"
+	ErrUnknownAccount     = "unknown_account"
+	ErrBadCredentials     = "bad_credentials"
+	ErrAlreadyOnline      = "already_online"
+	ErrTooManyAttempts    = "too_many_attempts"
+	ErrResumeFailed       = "resume_failed"
+	ErrBanned             = "banned"
+	ErrServer             = "server_error"
+)
+
+// Envelope wraps every payload on the wire. Seq counts the frames sent by
+// each side, starting at 1.
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    Type            `json:"type"`
+	Seq     uint64          `json:"seq"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type Hello struct {
+	Versions []int  `json:"versions"`
+	Client   string `json:"client,omitempty"`
+}
+
+type Welcome struct {
+	Version int    `json:"version"`
+	Server  string `json:"server,omitempty"`
+}
+
+// Login starts a new session with the account password, or resumes a
+// dropped one when ResumeToken is the token handed out by the LoginOK of
+// that session.
+type Login struct {
+	Name        string `json:"name"`
+	Password    string `json:"password,omitempty"`
+	ResumeToken string `json:"resume_token,omitempty"`
+}
+
+type Register struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type LoginOK struct {
+	Name        string `json:"name"`
+	ResumeToken string `json:"resume_token"`
+	Resumed     bool   `json:"resumed,omitempty"`
+}
+
+type ModeSelect struct {
+	Mode string `json:"mode"`
+}
+
+type Notice struct {
+	Text string `json:"text"`
+}
+
+type MapUpdate struct {
+	Map string `json:"map"`
+}
+
+// Kinds of Entity.
+const (
+	EntityPlayer  = "player"
+	EntityPokemon = "pokemon"
+)
+
+// Entity is something standing on a tile of the world. IDs are unique per
+// kind: player names and Pokemon IDs.
+type Entity struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+	Level  int    `json:"level,omitempty"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+}
+
+// Terrain of a tile, one byte per tile in Viewport.Terrain. Map files use
+// the same letters.
+const (
+	TerrainGrass     = '.'
+	TerrainTallGrass = ','
+	TerrainWater     = '~'
+	TerrainCave      = 'o'
+	TerrainMountain  = '^'
+	TerrainCity      = 'C'
+	TerrainPath      = '='
+	TerrainWall      = '#' // cannot be walked on
+)
+
+// Viewport is the part of the world a catching player sees, centered on
+// their avatar, with everything on it. X and Y are the top left tile; as
+// everywhere in the world X counts rows, Y columns, and both wrap around at
+// WorldSize. Terrain has one string per row.
+type Viewport struct {
+	X         int      `json:"x"`
+	Y         int      `json:"y"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	WorldSize int      `json:"world_size"`
+	Terrain   []string `json:"terrain,omitempty"`
+	Entities  []Entity `json:"entities"`
+}
+
+// Ops of EntityChange.
+const (
+	ChangeSpawn   = "spawn"
+	ChangeMove    = "move"
+	ChangeDespawn = "despawn"
+)
+
+// WorldDelta is what changed in a viewport since the last Viewport or
+// WorldDelta. An entity that moves out of the viewport is still sent once,
+// with its new tile, so the client can drop it.
+type WorldDelta struct {
+	Changes []EntityChange `json:"changes"`
+}
+
+type EntityChange struct {
+	Op     string `json:"op"`
+	Entity Entity `json:"entity"`
+}
+
+type Move struct {
+	Direction string `json:"direction"`
+}
+
+type BattlePrompt struct {
+	Text string `json:"text"`
+}
+
+type BattleEvent struct {
+	Text string `json:"text"`
+}
+
+type Input struct {
+	Text string `json:"text"`
+}
+
+type Chat struct {
+	From string `json:"from,omitempty"`
+	Text string `json:"text"`
+}
+
+type Bye struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Decode unmarshals the payload into v.
+func (e Envelope) Decode(v any) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%s frame without payload", e.Type)
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("decode %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// WriteFrame writes one length-prefixed envelope.
+func WriteFrame(w io.Writer, env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("encode %s frame: %w", env.Type, err)
+	}
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("%s frame is %d bytes, limit is %d", env.Type, len(data), MaxFrameSize)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one length-prefixed envelope.
+func ReadFrame(r io.Reader) (Envelope, error) {
+	var env Envelope
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return env, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return env, fmt.Errorf("frame of %d bytes exceeds limit of %d", size, MaxFrameSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return env, err
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, fmt.Errorf("decode frame: %w", err)
+	}
+	return env, nil
+}
+
+// Framer reads and writes whole envelopes. Streams use length-prefixed
+// frames; transports with their own message boundaries, like WebSockets,
+// carry one envelope per message.
+type Framer interface {
+	ReadEnvelope() (Envelope, error)
+	WriteEnvelope(Envelope) error
+}
+
+// streamFramer is the Framer of a byte stream.
+type streamFramer struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (f *streamFramer) ReadEnvelope() (Envelope, error) {
+	return ReadFrame(f.r)
+}
+
+func (f *streamFramer) WriteEnvelope(env Envelope) error {
+	return WriteFrame(f.w, env)
+}
+
+// Conn sends and receives envelopes. Send may be called from several
+// goroutines, Receive from one at a time.
+type Conn struct {
+	frames  Framer
+	mu      sync.Mutex
+	seq     uint64
+	version int
+}
+
+// NewConn speaks length-prefixed frames over rw.
+func NewConn(rw io.ReadWriter) *Conn {
+	return NewFramedConn(&streamFramer{w: rw, r: bufio.NewReader(rw)})
+}
+
+// NewFramedConn speaks over a transport that frames envelopes itself.
+func NewFramedConn(frames Framer) *Conn {
+	return &Conn{frames: frames, version: Version}
+}
+
+// Version returns the negotiated protocol version.
+func (c *Conn) Version() int {
+	return c.version
+}
+
+// Send wraps payload in an envelope and writes it.
+func (c *Conn) Send(t Type, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s payload: %w", t, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return c.frames.WriteEnvelope(Envelope{Version: c.version, Type: t, Seq: c.seq, Payload: data})
+}
+
+// Receive reads the next envelope.
+func (c *Conn) Receive() (Envelope, error) {
+	return c.frames.ReadEnvelope()
+}
+
+// ClientHandshake offers Version to the server and waits for its answer.
+func (c *Conn) ClientHandshake(client string) (Welcome, error) {
+	var welcome Welcome
+	if err := c.Send(TypeHello, Hello{Versions: []int{Version}, Client: client}); err != nil {
+		return welcome, err
+	}
+	env, err := c.Receive()
+	if err != nil {
+		return welcome, err
+	}
+	switch env.Type {
+	case TypeWelcome:
+		if err := env.Decode(&welcome); err != nil {
+			return welcome, err
+		}
+		c.version = welcome.Version
+		return welcome, nil
+	case TypeError:
+		var e Error
+		if err := env.Decode(&e); err != nil {
+			return welcome, err
+		}
+		return welcome, e
+	}
+	return welcome, fmt.Errorf("unexpected %s frame during handshake", env.Type)
+}
+
+// ServerHandshake waits for the client's Hello and picks the newest version
+// both sides speak. The client is told about a failed negotiation before the
+// error is returned.
+func (c *Conn) ServerHandshake(server string) (Hello, error) {
+	var hello Hello
+	env, err := c.Receive()
+	if err != nil {
+		return hello, err
+	}
+	if env.Type != TypeHello {
+		e := Error{Code: ErrBadRequest, Message: "expected hello"}
+		c.Send(TypeError, e)
+		return hello, e
+	}
+	if err := env.Decode(&hello); err != nil {
+		e := Error{Code: ErrBadRequest, Message: err.Error()}
+		c.Send(TypeError, e)
+		return hello, e
+	}
+	chosen := 0
+	for _, v := range hello.Versions {
+		if v <= Version && v > chosen {
+			chosen = v
+		}
+	}
+	if chosen == 0 {
+		e := Error{Code: ErrUnsupportedVersion, Message: fmt.Sprintf("server speaks protocol version %d", Version)}
+		c.Send(TypeError, e)
+		return hello, e
+	}
+	c.version = chosen
+	return hello, c.Send(TypeWelcome, Welcome{Version: chosen, Server: server})
+}
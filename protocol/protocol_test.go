@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	payload, _ := json.Marshal(Chat{From: "Ash", Text: "hello"})
+	sent := Envelope{Version: Version, Type: TypeChat, Seq: 7, Payload: payload}
+	errc := make(chan error, 1)
+	go func() { errc <- WriteFrame(client, sent) }()
+
+	got, err := ReadFrame(server)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if got.Version != sent.Version || got.Type != sent.Type || got.Seq != sent.Seq {
+		t.Errorf("envelope = %+v, want %+v", got, sent)
+	}
+	var chat Chat
+	if err := got.Decode(&chat); err != nil {
+		t.Fatal(err)
+	}
+	if chat.From != "Ash" || chat.Text != "hello" {
+		t.Errorf("payload = %+v, want Ash: hello", chat)
+	}
+}
+
+func TestFrameSizeLimit(t *testing.T) {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], MaxFrameSize+1)
+	if _, err := ReadFrame(bytes.NewReader(header[:])); err == nil {
+		t.Error("ReadFrame accepted a frame over MaxFrameSize")
+	}
+
+	text, _ := json.Marshal(strings.Repeat("x", MaxFrameSize))
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, Envelope{Type: TypeNotice, Payload: text}); err == nil {
+		t.Error("WriteFrame wrote a frame over MaxFrameSize")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteFrame wrote %d bytes of an oversized frame", buf.Len())
+	}
+}
+
+// handshake runs the server side of the handshake against a client that
+// offers versions.
+func handshake(t *testing.T, versions ...int) (Envelope, *Conn, error) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() { clientConn.Close(); serverConn.Close() })
+	client, server := NewConn(clientConn), NewConn(serverConn)
+
+	type result struct {
+		hello Hello
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		hello, err := server.ServerHandshake("test")
+		done <- result{hello, err}
+	}()
+	if err := client.Send(TypeHello, Hello{Versions: versions, Client: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := client.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if len(r.hello.Versions) != len(versions) {
+		t.Errorf("server read versions %v, want %v", r.hello.Versions, versions)
+	}
+	return answer, server, r.err
+}
+
+func TestHandshakeNegotiatesVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []int
+		want     int
+	}{
+		{"v1 client", []int{1}, 1},
+		{"v2 client", []int{Version}, Version},
+		{"newest shared", []int{1, Version, Version + 1}, Version},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, server, err := handshake(t, tt.versions...)
+			if err != nil {
+				t.Fatalf("ServerHandshake: %v", err)
+			}
+			var welcome Welcome
+			if answer.Type != TypeWelcome || answer.Decode(&welcome) != nil {
+				t.Fatalf("answer = %+v, want a welcome", answer)
+			}
+			if welcome.Version != tt.want || server.Version() != tt.want {
+				t.Errorf("version = %d (server %d), want %d", welcome.Version, server.Version(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshakeRejectsUnsupportedVersion(t *testing.T) {
+	answer, _, err := handshake(t, Version+1)
+	var e Error
+	if !errors.As(err, &e) || e.Code != ErrUnsupportedVersion {
+		t.Fatalf("ServerHandshake error = %v, want %s", err, ErrUnsupportedVersion)
+	}
+	var sent Error
+	if answer.Type != TypeError || answer.Decode(&sent) != nil || sent.Code != ErrUnsupportedVersion {
+		t.Errorf("client got %+v, want an %s error", answer, ErrUnsupportedVersion)
+	}
+}
+
+func TestClientHandshake(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+	client, server := NewConn(clientConn), NewConn(serverConn)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := server.ServerHandshake("test")
+		errc <- err
+	}()
+	welcome, err := client.ClientHandshake("test")
+	if err != nil {
+		t.Fatalf("ClientHandshake: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ServerHandshake: %v", err)
+	}
+	if welcome.Version != Version || client.Version() != Version {
+		t.Errorf("version = %d (client %d), want %d", welcome.Version, client.Version(), Version)
+	}
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import "strings"
+
+// terrainTiles draws the tiles with nothing on them; avatars are emoji, so
+// every tile is two columns wide.
+var terrainTiles = map[byte]string{
+	TerrainGrass:     "￭ ",
+	TerrainTallGrass: "ψ ",
+	TerrainWater:     "≈ ",
+	TerrainCave:      "∩ ",
+	TerrainMountain:  "▲ ",
+	TerrainCity:      "⌂ ",
+	TerrainPath:      "░░",
+	TerrainWall:      "██",
+}
+
+// View is a client's copy of its viewport, kept current by applying the
+// Viewport and WorldDelta frames in the order they arrive.
+type View struct {
+	viewport Viewport
+	entities map[string]Entity // by kind and ID
+}
+
+func entityKey(e Entity) string {
+	return e.Kind + "/" + e.ID
+}
+
+// Reset replaces the view with a fresh viewport.
+func (v *View) Reset(vp Viewport) {
+	v.viewport = vp
+	v.entities = make(map[string]Entity, len(vp.Entities))
+	for _, e := range vp.Entities {
+		v.entities[entityKey(e)] = e
+	}
+}
+
+// Apply updates the view with the changes of a delta.
+func (v *View) Apply(d WorldDelta) {
+	if v.entities == nil {
+		v.entities = map[string]Entity{}
+	}
+	for _, change := range d.Changes {
+		key := entityKey(change.Entity)
+		if change.Op == ChangeDespawn || !v.Contains(change.Entity.X, change.Entity.Y) {
+			delete(v.entities, key)
+			continue
+		}
+		v.entities[key] = change.Entity
+	}
+}
+
+// Contains reports whether tile x,y of the world is in the view.
+func (v *View) Contains(x, y int) bool {
+	_, _, ok := v.cell(x, y)
+	return ok
+}
+
+// cell returns where tile x,y is drawn in the view.
+func (v *View) cell(x, y int) (int, int, bool) {
+	size := v.viewport.WorldSize
+	if size <= 0 {
+		return 0, 0, false
+	}
+	row := ((x-v.viewport.X)%size + size) % size
+	col := ((y-v.viewport.Y)%size + size) % size
+	return row, col, row < v.viewport.Height && col < v.viewport.Width
+}
+
+func (v *View) terrainTile(row, col int) string {
+	terrain := byte(TerrainGrass)
+	if row < len(v.viewport.Terrain) && col < len(v.viewport.Terrain[row]) {
+		terrain = v.viewport.Terrain[row][col]
+	}
+	if tile, ok := terrainTiles[terrain]; ok {
+		return tile
+	}
+	return terrainTiles[TerrainGrass]
+}
+
+// Render draws the view as text, one line per row.
+func (v *View) Render() string {
+	grid := make([][]string, v.viewport.Height)
+	for i := range grid {
+		grid[i] = make([]string, v.viewport.Width)
+	}
+	for _, e := range v.entities {
+		if row, col, ok := v.cell(e.X, e.Y); ok {
+			grid[row][col] = e.Avatar
+		}
+	}
+	var b strings.Builder
+	b.WriteString("\n\n\n")
+	for i, row := range grid {
+		for j, tile := range row {
+			if tile == "" {
+				tile = v.terrainTile(i, j)
+			}
+			b.WriteString(tile)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pokeGame/protocol"
+)
+
+// battleAction is what a participant decided to do in one turn. kind is
+// "move", "switch", "item" or "forfeit", or "suspend" when the server
+// stops; a move with a nil slot is Struggle. Wild battles add "ball", "run"
+// and "leave", for a player who is gone.
+type battleAction struct {
+	kind    string
+	slot    *MoveSlot
+	pokemon *OwnedPokemon
+	ball    *ball
+}
+
+// readInputs passes the Input frames of the participant's current session
+// on to its input channel as trimmed lines. Chat frames go to everybody. When
+// the connection breaks the participant is held for a reconnect; the input
+// channel is only closed once the grace period runs out.
+func readInputs(p *Participant) {
+	session := p.currentSession()
+	for {
+		env, err := session.conn.Receive()
+		if err != nil {
+			p.disconnect(session)
+			return
+		}
+		switch env.Type {
+		case protocol.TypeInput:
+			var input protocol.Input
+			if err := env.Decode(&input); err != nil {
+				session.Send(errorMessage(protocol.ErrBadRequest, err.Error()))
+				continue
+			}
+			p.input <- strings.TrimSpace(input.Text)
+		case protocol.TypeChat:
+			relayChat(session, p.player.Name, env)
+		default:
+			session.Send(errorMessage(protocol.ErrBadRequest, fmt.Sprintf("unexpected %s frame during a battle", env.Type)))
+		}
+	}
+}
+
+// String describes the action for the audit log.
+func (a battleAction) String() string {
+	switch a.kind {
+	case "move":
+		return "move " + slotMove(a.slot).Name
+	case "switch":
+		return "switch " + a.pokemon.Name
+	case "item":
+		return "potion"
+	case "ball":
+		return "ball " + a.ball.name
+	}
+	return a.kind
+}
+
+// promptActions asks both participants for their action at the same time.
+func promptActions(turn int, participant1, participant2 *Participant) (battleAction, battleAction) {
+	var action1, action2 battleAction
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		action1 = readAction(turn, participant1, participant2)
+	}()
+	go func() {
+		defer wg.Done()
+		action2 = readAction(turn, participant2, participant1)
+	}()
+	wg.Wait()
+	return action1, action2
+}
+
+// readAction waits up to the turn timeout for a valid action and falls back to a
+// random move. The clock is restarted while the participant is disconnected,
+// and a participant that does not come back forfeits.
+func readAction(turn int, self, opponent *Participant) battleAction {
+	self.prompt(actionPrompt(turn, self, opponent))
+	defer self.clearPrompt()
+	deadline := time.After(cfg.Battle.TurnTimeout)
+	for {
+		select {
+		case line, ok := <-self.input:
+			if !ok {
+				return battleAction{kind: "forfeit"}
+			}
+			if handleCommand(self, line) {
+				continue
+			}
+			action, err := parseAction(self, line)
+			if err != nil {
+				self.send(battlePrompt(err.Error() + "\nYour action: "))
+				continue
+			}
+			return action
+		case <-deadline:
+			if !self.isConnected() {
+				deadline = time.After(cfg.Battle.TurnTimeout)
+				continue
+			}
+			self.send(battleEvent("\n⏰ Time is up, a random move was chosen for you.\n"))
+			return battleAction{kind: "move", slot: chooseMove(self.curPokemon)}
+		case <-stopping:
+			return battleAction{kind: "suspend"}
+		}
+	}
+}
+
+func actionPrompt(turn int, self, opponent *Participant) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n------------TURN %d------------\n", turn)
+	fmt.Fprintf(&b, "Your %s (Lv %d) HP %d/%d vs %s's %s (Lv %d) HP %d/%d\n",
+		self.curPokemon.Name, self.curPokemon.Level, self.curPokemon.HP, self.curPokemon.MaxHP,
+		opponent.player.Name, opponent.curPokemon.Name, opponent.curPokemon.Level, opponent.curPokemon.HP, opponent.curPokemon.MaxHP)
+	writeOptions(&b, self)
+	b.WriteString("f. forfeit\n")
+	fmt.Fprintf(&b, "Your action (%ds): ", int(cfg.Battle.TurnTimeout.Seconds()))
+	return b.String()
+}
+
+// writeOptions lists the moves, switches and Potion self can use.
+func writeOptions(b *strings.Builder, self *Participant) {
+	for i, slot := range self.curPokemon.Moves {
+		if m := findMove(slot.Name); m != nil {
+			fmt.Fprintf(b, "%d. %s (%s, %s, power %d) PP %d/%d\n", i+1, m.Name, m.Type, m.Category, m.Power, slot.PP, slot.MaxPP)
+		}
+	}
+	if len(self.curPokemon.usableMoves()) == 0 {
+		b.WriteString("No PP left: any move number uses Struggle\n")
+	}
+	for i, p := range self.player.PokemonList {
+		if p.Deployable && p.HP > 0 && p != self.curPokemon {
+			fmt.Fprintf(b, "s %d. switch to %s (HP %d/%d)\n", i+1, p.Name, p.HP, p.MaxHP)
+		}
+	}
+	if self.potions > 0 {
+		fmt.Fprintf(b, "i. use a Potion, heals %d HP (%d left)\n", cfg.Battle.PotionHeal, self.potions)
+	}
+}
+
+func parseAction(self *Participant, line string) (battleAction, error) {
+	fields := strings.Fields(strings.ToLower(line))
+	if len(fields) == 0 {
+		return battleAction{}, fmt.Errorf("Please choose an action.")
+	}
+	switch fields[0] {
+	case "f", "forfeit":
+		return battleAction{kind: "forfeit"}, nil
+	case "i", "item":
+		if self.potions <= 0 {
+			return battleAction{}, fmt.Errorf("You have no Potions left.")
+		}
+		if self.curPokemon.HP >= self.curPokemon.MaxHP {
+			return battleAction{}, fmt.Errorf("%s already has full HP.", self.curPokemon.Name)
+		}
+		return battleAction{kind: "item"}, nil
+	case "s", "switch":
+		if len(fields) < 2 {
+			return battleAction{}, fmt.Errorf("Type s followed by the Pokemon number.")
+		}
+		index, err := strconv.Atoi(fields[1])
+		if err != nil || index < 1 || index > len(self.player.PokemonList) {
+			return battleAction{}, fmt.Errorf("Please choose a Pokemon from the list.")
+		}
+		next := self.player.PokemonList[index-1]
+		if next == self.curPokemon {
+			return battleAction{}, fmt.Errorf("%s is already fighting.", next.Name)
+		}
+		if !next.Deployable || next.HP <= 0 {
+			return battleAction{}, fmt.Errorf("%s lost the ability to fight.", next.Name)
+		}
+		return battleAction{kind: "switch", pokemon: next}, nil
+	}
+	index, err := strconv.Atoi(fields[0])
+	if err != nil || index < 1 || index > len(self.curPokemon.Moves) {
+		return battleAction{}, fmt.Errorf("Please choose a move number, s <n>, i or f.")
+	}
+	if len(self.curPokemon.usableMoves()) == 0 {
+		return battleAction{kind: "move"}, nil
+	}
+	slot := self.curPokemon.Moves[index-1]
+	if slot.PP <= 0 {
+		return battleAction{}, fmt.Errorf("%s has no PP left.", slot.Name)
+	}
+	return battleAction{kind: "move", slot: slot}, nil
+}
+
+// choosePokemon asks for the next Pokemon to send out. A zero timeout waits
+// forever; on timeout a random deployable Pokemon is picked. It reports true
+// if the participant surrendered or left, and returns no Pokemon and false
+// when the server stops.
+func choosePokemon(self *Participant, prompt string, timeout time.Duration) (*OwnedPokemon, bool) {
+	self.prompt(prompt)
+	defer self.clearPrompt()
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	for {
+		select {
+		case line, ok := <-self.input:
+			if !ok {
+				return nil, true
+			}
+			if handleCommand(self, line) {
+				continue
+			}
+			index, _ := strconv.Atoi(line)
+			// Check if the player wants to surrender
+			if index == -1 {
+				return nil, true
+			}
+			if index < 1 || index > len(self.player.PokemonList) {
+				self.send(battlePrompt("Please choose a number from the list.\n"))
+				continue
+			}
+			chosenPokemon := self.player.PokemonList[index-1]
+			if chosenPokemon.Deployable && chosenPokemon.HP > 0 {
+				return chosenPokemon, false
+			}
+			// If the Pokemon is not deployable, ask for another Pokemon
+			self.send(battlePrompt("This Pokemon lost the ability to fight. Please choose another one.\n"))
+		case <-deadline:
+			if !self.isConnected() {
+				deadline = time.After(timeout)
+				continue
+			}
+			deployable := deployablePokemon(self.player)
+			if len(deployable) == 0 {
+				return nil, true
+			}
+			self.send(battleEvent("\n⏰ Time is up, a random Pokemon was chosen for you.\n"))
+			return deployable[rand.Intn(len(deployable))], false
+		case <-stopping:
+			return nil, false
+		}
+	}
+}
+
+func deployablePokemon(player *Player) []*OwnedPokemon {
+	var deployable []*OwnedPokemon
+	for _, p := range player.PokemonList {
+		if p.Deployable && p.HP > 0 {
+			deployable = append(deployable, p)
+		}
+	}
+	return deployable
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminCommand is one line of the admin console. run returns what is
+// printed back to the operator.
+type adminCommand struct {
+	usage string
+	run   func(args []string) (string, error)
+}
+
+var adminCommands map[string]adminCommand
+
+func init() {
+	// set here because help lists the table itself
+	adminCommands = map[string]adminCommand{
+		"help":         {"help", adminHelp},
+		"players":      {"players", adminPlayers},
+		"kick":         {"kick <player> [reason]", adminKick},
+		"ban":          {"ban <player> [reason]", adminBan},
+		"unban":        {"unban <player>", adminUnban},
+		"bans":         {"bans", adminBans},
+		"spawn":        {"spawn <species> at <x>,<y> [level] | spawn wave", adminSpawn},
+		"despawn":      {"despawn all", adminDespawn},
+		"grant":        {"grant <player> <species> [level]", adminGrant},
+		"set-level":    {"set-level <player> <pokemon name or id> <level>", adminSetLevel},
+		"set-password": {"set-password <player> <password>", adminSetPassword},
+		"broadcast":    {"broadcast <text>", adminBroadcast},
+		"save-now":     {"save-now", adminSaveNow},
+	}
+}
+
+// startAdmin opens the admin console on addr. It speaks plain lines, so
+// `nc localhost 3016` is enough; the address is checked to be loopback at
+// startup since anybody who reaches it is an operator.
+func startAdmin(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				slog.Warn("admin accept", "err", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go serveAdmin(conn)
+		}
+	}()
+	slog.Info("admin console listening", "addr", listener.Addr().String())
+	return listener, nil
+}
+
+func serveAdmin(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	slog.Info("admin connected", "remote", remote)
+	fmt.Fprint(conn, "pokeGame admin console, type help for the commands\n> ")
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if line != "" {
+			out, err := runAdminCommand(line)
+			logged := redactAdminLine(line)
+			if err != nil {
+				out = "error: " + err.Error()
+				audit.Info("admin", "command", logged, "remote", remote, "err", err.Error())
+			} else {
+				audit.Info("admin", "command", logged, "remote", remote)
+			}
+			fmt.Fprintln(conn, strings.TrimRight(out, "\n"))
+		}
+		fmt.Fprint(conn, "> ")
+	}
+}
+
+func runAdminCommand(line string) (string, error) {
+	fields := strings.Fields(line)
+	cmd, ok := adminCommands[strings.ToLower(fields[0])]
+	if !ok {
+		return "", fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+	return cmd.run(fields[1:])
+}
+
+// redactAdminLine hides the password of set-password from the audit log.
+func redactAdminLine(line string) string {
+	fields := strings.Fields(line)
+	if strings.EqualFold(fields[0], "set-password") && len(fields) > 2 {
+		return strings.Join(append(fields[:2], "***"), " ")
+	}
+	return line
+}
+
+func usageError(name string) error {
+	return fmt.Errorf("usage: %s", adminCommands[name].usage)
+}
+
+func adminHelp([]string) (string, error) {
+	var usages []string
+	for _, cmd := range adminCommands {
+		usages = append(usages, "  "+cmd.usage)
+	}
+	sort.Strings(usages)
+	return "commands:\n" + strings.Join(usages, "\n") + "\n  quit", nil
+}
+
+func adminPlayers([]string) (string, error) {
+	mu.Lock()
+	playing := append([]*Participant(nil), participants...)
+	online := len(onlineAccounts)
+	mu.Unlock()
+	var lines []string
+	for _, p := range playing {
+		mode := "battle"
+		if p.catchMode {
+			mode = "catch"
+		}
+		state := "connected"
+		if !p.isConnected() {
+			state = "reconnecting"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s, %s)", p.player.Name, mode, state))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%d online, %d playing\n%s", online, len(lines), strings.Join(lines, "\n")), nil
+}
+
+func adminKick(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", usageError("kick")
+	}
+	reason := "You were kicked by an admin."
+	if len(args) > 1 {
+		reason = "You were kicked: " + strings.Join(args[1:], " ")
+	}
+	if !kickPlayer(args[0], reason) {
+		return "", fmt.Errorf("%s is not online", args[0])
+	}
+	return fmt.Sprintf("kicked %s", args[0]), nil
+}
+
+// kickPlayer ends the session of name, whether it is playing or still
+// choosing a mode.
+func kickPlayer(name, reason string) bool {
+	if p := findParticipant(name); p != nil {
+		p.kick(reason)
+		return true
+	}
+	if s := findSession(name); s != nil {
+		s.Send(bye(reason))
+		s.Close()
+		return true
+	}
+	return false
+}
+
+func adminBan(args []string) (string, error) {
+	if len(args) < 1 {
+		return "", usageError("ban")
+	}
+	name, reason := args[0], strings.Join(args[1:], " ")
+	if err := bans.add(name, reason); err != nil {
+		return "", fmt.Errorf("save bans: %w", err)
+	}
+	kickReason := "You were banned."
+	if reason != "" {
+		kickReason = "You were banned: " + reason
+	}
+	kickPlayer(name, kickReason)
+	return fmt.Sprintf("banned %s", name), nil
+}
+
+func adminUnban(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("unban")
+	}
+	removed, err := bans.remove(args[0])
+	if err != nil {
+		return "", fmt.Errorf("save bans: %w", err)
+	}
+	if !removed {
+		return "", fmt.Errorf("%s is not banned", args[0])
+	}
+	return fmt.Sprintf("unbanned %s", args[0]), nil
+}
+
+func adminBans([]string) (string, error) {
+	list := bans.list()
+	if len(list) == 0 {
+		return "nobody is banned", nil
+	}
+	var lines []string
+	for _, ban := range list {
+		lines = append(lines, fmt.Sprintf("%s since %s %s", ban.Name, ban.At.Format(time.DateTime), ban.Reason))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func adminSpawn(args []string) (string, error) {
+	if len(args) == 1 && args[0] == "wave" {
+		world.spawnPokemonWave()
+		return "spawned a wave", nil
+	}
+	// species names may have spaces, so look for "at" from the end
+	at := -1
+	for i := len(args) - 1; i > 0; i-- {
+		if strings.EqualFold(args[i], "at") {
+			at = i
+			break
+		}
+	}
+	if at < 1 || at+1 >= len(args) || len(args) > at+3 {
+		return "", usageError("spawn")
+	}
+	species, err := lookupSpecies(strings.Join(args[:at], " "))
+	if err != nil {
+		return "", err
+	}
+	x, y, err := parseTile(args[at+1])
+	if err != nil {
+		return "", err
+	}
+	level := 1
+	if len(args) == at+3 {
+		if level, err = parseLevel(args[at+2]); err != nil {
+			return "", err
+		}
+	}
+	if err := world.spawnPokemonAt(species, level, x, y); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("spawned a level %d %s at %d,%d", level, species.Name, x, y), nil
+}
+
+func adminDespawn(args []string) (string, error) {
+	if len(args) != 1 || args[0] != "all" {
+		return "", usageError("despawn")
+	}
+	n := world.despawnAll()
+	return fmt.Sprintf("despawned %d Pokemon", n), nil
+}
+
+func adminGrant(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", usageError("grant")
+	}
+	name, speciesArgs, level := args[0], args[1:], 1
+	if len(speciesArgs) > 1 {
+		if l, err := parseLevel(speciesArgs[len(speciesArgs)-1]); err == nil {
+			level, speciesArgs = l, speciesArgs[:len(speciesArgs)-1]
+		}
+	}
+	species, err := lookupSpecies(strings.Join(speciesArgs, " "))
+	if err != nil {
+		return "", err
+	}
+	return editPlayer(name, func(player *Player) (string, error) {
+		if len(player.PokemonList) >= cfg.Team.MaxPokemon {
+			return "", fmt.Errorf("%s already has %d Pokemon", player.Name, len(player.PokemonList))
+		}
+		pokemon := newPokemonInstance(species, level)
+		pokemon.CaughtAt = time.Now()
+		player.PokemonList = append(player.PokemonList, pokemon)
+		return fmt.Sprintf("gave %s a level %d %s (%s)", player.Name, level, pokemon.Name, pokemon.ID), nil
+	})
+}
+
+func adminSetLevel(args []string) (string, error) {
+	if len(args) < 3 {
+		return "", usageError("set-level")
+	}
+	level, err := parseLevel(args[len(args)-1])
+	if err != nil {
+		return "", err
+	}
+	name, which := args[0], strings.Join(args[1:len(args)-1], " ")
+	return editPlayer(name, func(player *Player) (string, error) {
+		for _, pokemon := range player.PokemonList {
+			if pokemon.ID == which || strings.EqualFold(pokemon.Name, which) {
+				pokemon.Level = level
+				pokemon.AccumExp = expForLevel(level)
+				pokemon.recalculateStats()
+				return fmt.Sprintf("%s's %s (%s) is now level %d", player.Name, pokemon.Name, pokemon.ID, level), nil
+			}
+		}
+		return "", fmt.Errorf("%s has no Pokemon %q", player.Name, which)
+	})
+}
+
+// adminSetPassword sets the password of an account, which is how players
+// saved before accounts existed get one.
+func adminSetPassword(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", usageError("set-password")
+	}
+	hash, err := hashPassword(args[1])
+	if err != nil {
+		return "", err
+	}
+	return editPlayer(args[0], func(player *Player) (string, error) {
+		player.PasswordHash = hash
+		return fmt.Sprintf("set the password of %s", player.Name), nil
+	})
+}
+
+// editPlayer changes a profile and saves it. Offline players are edited in
+// the store, with the account claimed so they cannot log in meanwhile;
+// catching players are edited in the world. Battle-mode profiles belong to
+// their battle until it ends.
+func editPlayer(name string, change func(*Player) (string, error)) (string, error) {
+	if claimAccount(name) {
+		defer releaseAccount(name)
+		player, ok := playerRepo.Get(name)
+		if !ok {
+			return "", fmt.Errorf("there is no player named %s", name)
+		}
+		bindRoster(player)
+		out, err := change(player)
+		if err != nil {
+			return "", err
+		}
+		return out, playerRepo.Save(player)
+	}
+	p := findParticipant(name)
+	if p == nil || !p.catchMode {
+		return "", fmt.Errorf("%s is online outside the world, try again once they leave", name)
+	}
+	// change under the world lock, then save a copy without holding it
+	world.mux.Lock()
+	out, err := change(p.player)
+	snapshot := clonePlayer(p.player)
+	world.mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return out, playerRepo.Save(snapshot)
+}
+
+func adminBroadcast(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", usageError("broadcast")
+	}
+	broadcast(notice("📢 " + strings.Join(args, " ") + "\n"))
+	return "sent", nil
+}
+
+func adminSaveNow([]string) (string, error) {
+	return saveNow()
+}
+
+// saveNow saves everybody in the world and compacts the journal.
+// Battle-mode players are saved when their battle ends.
+func saveNow() (string, error) {
+	mu.Lock()
+	catchers := listOfCatchMode(participants)
+	mu.Unlock()
+	// copy the teams under the world lock, then save without holding it
+	world.mux.Lock()
+	snapshots := make([]*Player, 0, len(catchers))
+	for _, p := range catchers {
+		snapshots = append(snapshots, clonePlayer(p.player))
+	}
+	world.mux.Unlock()
+	var errs []error
+	for _, p := range snapshots {
+		if err := playerRepo.Save(p); err != nil {
+			errs = append(errs, fmt.Errorf("save %s: %w", p.Name, err))
+		}
+	}
+	out := fmt.Sprintf("saved %d players", len(catchers)-len(errs))
+	if c, ok := playerRepo.(compactor); ok {
+		if err := c.Compact(); err != nil {
+			errs = append(errs, fmt.Errorf("compact: %w", err))
+		} else {
+			out += ", journal compacted"
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+func lookupSpecies(name string) (*Pokemon, error) {
+	if species := speciesByIndex(name); species != nil {
+		return species, nil
+	}
+	if species, ok := findPokemon(pokedex, name); ok {
+		return species, nil
+	}
+	return nil, fmt.Errorf("no Pokemon named %q in the Pokedex", name)
+}
+
+func parseTile(s string) (int, int, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if !ok || errX != nil || errY != nil {
+		return 0, 0, fmt.Errorf("%q is not a tile, use x,y", s)
+	}
+	return x, y, nil
+}
+
+func parseLevel(s string) (int, error) {
+	level, err := strconv.Atoi(s)
+	if err != nil || level < 1 || level > maxLevel {
+		return 0, fmt.Errorf("the level must be between 1 and %d", maxLevel)
+	}
+	return level, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The read-only API mirrors the game structs: Pokedex entries are Pokemon,
+// rosters are OwnedPokemon and records are BattleRecord. Nothing private
+// like password hashes or resume tokens is exposed.
+
+// PlayerView is a player as the API shows it.
+type PlayerView struct {
+	Name    string          `json:"name"`
+	Online  bool            `json:"online"`
+	Rating  int             `json:"rating"`
+	Wins    int             `json:"wins"`
+	Losses  int             `json:"losses"`
+	Draws   int             `json:"draws"`
+	Roster  []*OwnedPokemon `json:"roster"`
+	History []BattleRecord  `json:"history"`
+}
+
+// WorldView is the catch-mode world with its spawn timers.
+type WorldView struct {
+	Size          int           `json:"size"`
+	SpawnInterval string        `json:"spawn_interval"`
+	NextWave      *time.Time    `json:"next_wave,omitempty"` // unset while nobody is catching
+	Players       []AvatarView  `json:"players"`
+	Pokemon       []WildPokemon `json:"pokemon"`
+}
+
+type AvatarView struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+}
+
+type WildPokemon struct {
+	Index     string    `json:"index"`
+	Name      string    `json:"name"`
+	Level     int       `json:"level"`
+	X         int       `json:"x"`
+	Y         int       `json:"y"`
+	SpawnedAt time.Time `json:"spawned_at"`
+	DespawnAt time.Time `json:"despawn_at"`
+}
+
+// BattleView is an active battle as of its last update.
+type BattleView struct {
+	ID      int          `json:"id"`
+	Started time.Time    `json:"started"`
+	Turn    int          `json:"turn"`
+	Sides   []BattleSide `json:"sides"`
+}
+
+type BattleSide struct {
+	Player     string `json:"player"`
+	Connected  bool   `json:"connected"`
+	Pokemon    string `json:"pokemon"`
+	Level      int    `json:"level"`
+	HP         int    `json:"hp"`
+	MaxHP      int    `json:"max_hp"`
+	FaintsLeft int    `json:"faints_left"`
+	Potions    int    `json:"potions"`
+}
+
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /pokedex", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, pokedex)
+	})
+	mux.HandleFunc("GET /pokedex/{index}", func(w http.ResponseWriter, r *http.Request) {
+		species := speciesByIndex(r.PathValue("index"))
+		if species == nil {
+			writeJSONError(w, http.StatusNotFound, "no Pokemon with index "+r.PathValue("index"))
+			return
+		}
+		writeJSON(w, http.StatusOK, species)
+	})
+	mux.HandleFunc("GET /players/{name}", func(w http.ResponseWriter, r *http.Request) {
+		player, ok := playerRepo.Get(r.PathValue("name"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no player named "+r.PathValue("name"))
+			return
+		}
+		writeJSON(w, http.StatusOK, playerView(player))
+	})
+	mux.HandleFunc("GET /world", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, world.view())
+	})
+	mux.HandleFunc("GET /battles", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, battleViews())
+	})
+}
+
+func playerView(p *Player) PlayerView {
+	mu.Lock()
+	online := onlineAccounts[playerKey(p.Name)]
+	mu.Unlock()
+	return PlayerView{
+		Name:    p.Name,
+		Online:  online,
+		Rating:  currentRating(p),
+		Wins:    p.Wins,
+		Losses:  p.Losses,
+		Draws:   p.Draws,
+		Roster:  p.PokemonList,
+		History: p.History,
+	}
+}
+
+func (w *World) view() WorldView {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	view := WorldView{
+		Size:          w.size,
+		SpawnInterval: cfg.World.SpawnInterval.String(),
+		Players:       []AvatarView{},
+		Pokemon:       []WildPokemon{},
+	}
+	if !w.lastWave.IsZero() {
+		next := w.lastWave.Add(cfg.World.SpawnInterval)
+		view.NextWave = &next
+	}
+	for _, p := range w.players {
+		view.Players = append(view.Players, AvatarView{Name: p.Name, Avatar: p.avatar, X: p.pos.X, Y: p.pos.Y})
+	}
+	sort.Slice(view.Players, func(i, j int) bool { return view.Players[i].Name < view.Players[j].Name })
+	for _, p := range w.pokemons {
+		view.Pokemon = append(view.Pokemon, WildPokemon{
+			Index:     p.Index,
+			Name:      p.Name,
+			Level:     p.Level,
+			X:         p.pos.X,
+			Y:         p.pos.Y,
+			SpawnedAt: p.spawnTime,
+			// deSpawnPokemons takes them a little early
+			DespawnAt: p.spawnTime.Add(cfg.World.DespawnAfter - 2*time.Second),
+		})
+	}
+	return view
+}
+
+func battleViews() []BattleView {
+	battleMu.Lock()
+	defer battleMu.Unlock()
+	views := []BattleView{}
+	for _, s := range battles {
+		views = append(views, s.status)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ID < views[j].ID })
+	return views
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("encode API response", "err", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
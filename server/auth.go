@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"pokeGame/protocol"
+)
+
+const (
+	minPasswordLength = 6
+	maxLoginFailures  = 5 // the connection is closed after this many
+	loginBackoff      = time.Second
+)
+
+// authError is a failed login or registration with the code sent to the
+// client.
+type authError struct {
+	code    string
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+var onlineAccounts = map[string]bool{}
+
+// registering holds the names with a registration under way, guarded by mu,
+// so two registrations cannot both find a name free and overwrite each other.
+var registering = map[string]bool{}
+
+// loginLimiter slows down password guessing on one connection: every failure
+// doubles the time before the next attempt is accepted.
+type loginLimiter struct {
+	failures int
+	next     time.Time
+}
+
+// wait returns how long the connection still has to wait before it may try
+// again.
+func (l *loginLimiter) wait() time.Duration {
+	return time.Until(l.next)
+}
+
+// fail records a failed attempt and reports whether the connection used up
+// its attempts.
+func (l *loginLimiter) fail() bool {
+	l.failures++
+	l.next = time.Now().Add(loginBackoff << (l.failures - 1))
+	return l.failures >= maxLoginFailures
+}
+
+// authenticate checks the password of an existing account.
+func authenticate(name, password string) (*Player, error) {
+	if err := checkBan(name); err != nil {
+		return nil, err
+	}
+	player, ok := playerRepo.Get(name)
+	if !ok {
+		return nil, &authError{protocol.ErrUnknownAccount, fmt.Sprintf("There is no account named %s.", name)}
+	}
+	if player.PasswordHash == "" {
+		return nil, &authError{protocol.ErrUnknownAccount, fmt.Sprintf("%s has no password yet, ask an operator to set one.", player.Name)}
+	}
+	if bcrypt.CompareHashAndPassword([]byte(player.PasswordHash), []byte(password)) != nil {
+		return nil, &authError{protocol.ErrBadCredentials, "Wrong name or password."}
+	}
+	return player, nil
+}
+
+// registerAccount creates an account with the starter Pokemon. Players saved
+// before accounts existed have no password; their names are taken like any
+// other, and an operator gives them one with the set-password command.
+func registerAccount(name, password string) (*Player, error) {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return nil, &authError{protocol.ErrBadRequest, "The name must be a single word."}
+	}
+	if err := checkBan(name); err != nil {
+		return nil, err
+	}
+	if !claimRegistration(name) {
+		return nil, &authError{protocol.ErrNameTaken, fmt.Sprintf("The name %s is being registered.", name)}
+	}
+	defer releaseRegistration(name)
+	if player, exists := playerRepo.Get(name); exists {
+		return nil, &authError{protocol.ErrNameTaken, fmt.Sprintf("The name %s is taken.", player.Name)}
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return createPlayer(pokedex, name, hash)
+}
+
+// hashPassword checks the length of a new password and hashes it.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", &authError{protocol.ErrBadRequest, fmt.Sprintf("The password needs at least %d characters.", minPasswordLength)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// claimRegistration reserves name for one registration and reports false if
+// another one holds it.
+func claimRegistration(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if registering[playerKey(name)] {
+		return false
+	}
+	registering[playerKey(name)] = true
+	return true
+}
+
+func releaseRegistration(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(registering, playerKey(name))
+}
+
+func checkBan(name string) error {
+	ban, banned := bans.check(name)
+	if !banned {
+		return nil
+	}
+	message := fmt.Sprintf("%s is banned.", ban.Name)
+	if ban.Reason != "" {
+		message = fmt.Sprintf("%s is banned: %s", ban.Name, ban.Reason)
+	}
+	return &authError{protocol.ErrBanned, message}
+}
+
+// claimAccount marks the account as online and reports false if it already
+// was.
+func claimAccount(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if onlineAccounts[playerKey(name)] {
+		return false
+	}
+	onlineAccounts[playerKey(name)] = true
+	return true
+}
+
+func releaseAccount(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(onlineAccounts, playerKey(name))
+}
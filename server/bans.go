@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban keeps an account from logging in.
+type Ban struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// banList is kept apart from the player profiles so that an online player
+// saving its profile cannot undo a ban.
+type banList struct {
+	path string
+	mu   sync.Mutex
+	bans map[string]Ban
+}
+
+var bans = &banList{bans: map[string]Ban{}}
+
+func (b *banList) load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []Ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, ban := range list {
+		b.bans[playerKey(ban.Name)] = ban
+	}
+	return nil
+}
+
+// check returns the ban of name, if any.
+func (b *banList) check(name string) (Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ban, ok := b.bans[playerKey(name)]
+	return ban, ok
+}
+
+func (b *banList) add(name, reason string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans[playerKey(name)] = Ban{Name: name, Reason: reason, At: time.Now()}
+	return b.flush()
+}
+
+// remove lifts the ban of name and reports whether there was one.
+func (b *banList) remove(name string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.bans[playerKey(name)]; !ok {
+		return false, nil
+	}
+	delete(b.bans, playerKey(name))
+	return true, b.flush()
+}
+
+func (b *banList) list() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sorted()
+}
+
+// sorted returns the bans oldest first; the caller holds b.mu.
+func (b *banList) sorted() []Ban {
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+	return list
+}
+
+// flush writes the list; the caller holds b.mu.
+func (b *banList) flush() error {
+	data, err := json.MarshalIndent(b.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.path, data)
+}
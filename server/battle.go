@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BattleSession is one fight between two participants with its own log.
+type BattleSession struct {
+	id           int
+	participant1 *Participant
+	participant2 *Participant
+	log          []string
+	started      time.Time
+	turn         int        // only touched by the battle goroutine
+	status       BattleView // what the API shows, guarded by battleMu
+}
+
+var (
+	battleMu     sync.Mutex
+	battles      = map[int]*BattleSession{}
+	nextBattleID = 1
+	battlesDone  sync.WaitGroup // lets a shutdown wait for the battles and encounters
+)
+
+// startBattle registers a session for the pair and plays it on its own
+// goroutine; any number of sessions can run at once.
+func startBattle(participant1, participant2 *Participant) *BattleSession {
+	battleMu.Lock()
+	s := &BattleSession{
+		id:           nextBattleID,
+		participant1: participant1,
+		participant2: participant2,
+		started:      time.Now(),
+	}
+	nextBattleID++
+	s.status = s.snapshot()
+	battles[s.id] = s
+	battlesDone.Add(1)
+	battleMu.Unlock()
+
+	go func() {
+		defer func() {
+			battleMu.Lock()
+			delete(battles, s.id)
+			battleMu.Unlock()
+			battlesDone.Done()
+		}()
+		slog.Info("battle started", "battle", s.id, "player1", participant1.player.Name, "player2", participant2.player.Name)
+		audit.Info("battle_start", "battle", s.id, "player1", participant1.player.Name, "player2", participant2.player.Name)
+		winner, loser, draw, suspended := s.run()
+		if suspended {
+			s.suspend()
+			return
+		}
+		battleDuration.since(s.started)
+		if draw {
+			battlesEnded.inc("draw")
+		} else {
+			battlesEnded.inc("win")
+		}
+		recordResult(winner.player, loser.player, draw)
+		for _, p := range []*Participant{winner, loser} {
+			if err := playerRepo.Save(p.player); err != nil {
+				slog.Error("save player", "player", p.player.Name, "err", err)
+			}
+		}
+		msg := fmt.Sprintf("\n🔴%s wins the battle - %s lost\n", winner.player.Name, loser.player.Name)
+		if draw {
+			msg = fmt.Sprintf("\n🤝 %s and %s drew the battle\n", winner.player.Name, loser.player.Name)
+		}
+		for _, p := range []*Participant{participant1, participant2} {
+			p.send(battleEvent(msg + fmt.Sprintf("Your rating is now %d\n", p.player.Rating)))
+		}
+		// remove all connections
+		for _, p := range []*Participant{participant1, participant2} {
+			closeCh <- p
+		}
+	}()
+	return s
+}
+
+// run plays the battle until it is decided and returns the winner and
+// loser; draw is set when both sides gave up in the same turn, suspended
+// when the server stopped first.
+func (s *BattleSession) run() (winner, loser *Participant, draw, suspended bool) {
+	participant1, participant2 := s.participant1, s.participant2
+	participant1.potions = cfg.Battle.Potions
+	participant2.potions = cfg.Battle.Potions
+	// Announce the current Pokemon
+	s.log = append(s.log, fmt.Sprintf("---%s chose %s\n%s chose %s\n", participant1.player.Name, participant1.curPokemon.Name, participant2.player.Name, participant2.curPokemon.Name))
+	s.log = append(s.log, "------------BATTLE START------------\n")
+	s.flush()
+
+	for turn := 1; ; turn++ {
+		s.turn = turn
+		// Both players choose at the same time, then the turn is resolved
+		action1, action2 := promptActions(turn, participant1, participant2)
+		if action1.kind == "suspend" || action2.kind == "suspend" {
+			return nil, nil, false, true
+		}
+		audit.Info("battle_turn", "battle", s.id, "turn", turn,
+			"player1", participant1.player.Name, "action1", action1.String(),
+			"player2", participant2.player.Name, "action2", action2.String())
+		if action1.kind == "forfeit" && action2.kind == "forfeit" {
+			s.log = append(s.log, "\nBATTLE END!!! \nBoth players forfeited, it's a draw!")
+			s.flush()
+			audit.Info("battle_end", "battle", s.id, "player1", participant1.player.Name, "player2", participant2.player.Name,
+				"draw", true, "turns", turn, "duration", time.Since(s.started).Round(time.Millisecond).String())
+			return participant1, participant2, true, false
+		}
+		if winner, loser := s.resolveTurn(participant1, participant2, action1, action2); winner != nil {
+			s.end(winner, loser, fmt.Sprintf("%s forfeited. %s wins!", loser.player.Name, winner.player.Name))
+			return winner, loser, false, false
+		}
+		s.flush()
+
+		// Ask the participants whose Pokemon fainted to choose another one
+		for _, pair := range [][2]*Participant{{participant1, participant2}, {participant2, participant1}} {
+			loser, winner := pair[0], pair[1]
+			if loser.curPokemon.HP > 0 {
+				continue
+			}
+			if loser.turn <= 0 || len(deployablePokemon(loser.player)) == 0 {
+				s.end(winner, loser, fmt.Sprintf("%s has no turns left. %s wins!", loser.player.Name, winner.player.Name))
+				return winner, loser, false, false
+			}
+			pokemonList := getListOfPokemon(loser.player.PokemonList)
+			next, surrendered := choosePokemon(loser, "\nYour Pokemon fainted, Let's choose another Pokemon\n"+pokemonList+"PRESS -1 to surrender - Your choice: ", cfg.Battle.TurnTimeout)
+			if surrendered {
+				s.end(winner, loser, fmt.Sprintf("%s surrendered. %s wins!", loser.player.Name, winner.player.Name))
+				return winner, loser, false, false
+			}
+			if next == nil {
+				return nil, nil, false, true
+			}
+			loser.sendOut(next)
+			s.log = append(s.log, fmt.Sprintf("%s sent out %s!\n", loser.player.Name, next.Name))
+			s.flush()
+		}
+	}
+}
+
+// suspend calls the battle off because the server stops. Nobody wins or
+// loses, but both rosters are saved as they are.
+func (s *BattleSession) suspend() {
+	audit.Info("battle_end", "battle", s.id, "player1", s.participant1.player.Name, "player2", s.participant2.player.Name,
+		"suspended", true, "turns", s.turn, "duration", time.Since(s.started).Round(time.Millisecond).String())
+	for _, p := range []*Participant{s.participant1, s.participant2} {
+		if err := playerRepo.Save(p.player); err != nil {
+			slog.Error("save player", "player", p.player.Name, "err", err)
+		}
+		p.send(battleEvent("\n⏸ The server is shutting down, the battle was called off. Your rating did not change.\n"))
+	}
+}
+
+// end hands out the experience and sends the final report.
+func (s *BattleSession) end(winner, loser *Participant, reason string) {
+	audit.Info("battle_end", "battle", s.id, "winner", winner.player.Name, "loser", loser.player.Name, "reason", reason,
+		"turns", s.turn, "duration", time.Since(s.started).Round(time.Millisecond).String())
+	s.log = append(s.log, fmt.Sprintf("\nBATTLE END!!! \n%s", reason))
+	levelUps := distributeExp(s.id, winner, loser)
+	s.flush()
+	announceLevelUps(winner, levelUps)
+}
+
+// flush streams what happened since the last flush to both sides.
+func (s *BattleSession) flush() {
+	if len(s.log) == 0 {
+		return
+	}
+	msg := strings.Join(s.log, "")
+	s.log = nil
+	s.participant1.send(battleEvent(msg))
+	s.participant2.send(battleEvent(msg))
+	s.publish()
+}
+
+// publish snapshots the battle for the API, which must not read the
+// participants while the battle changes them.
+func (s *BattleSession) publish() {
+	status := s.snapshot()
+	battleMu.Lock()
+	s.status = status
+	battleMu.Unlock()
+}
+
+func (s *BattleSession) snapshot() BattleView {
+	status := BattleView{ID: s.id, Started: s.started, Turn: s.turn}
+	for _, p := range []*Participant{s.participant1, s.participant2} {
+		side := BattleSide{Player: p.player.Name, Connected: p.isConnected(), FaintsLeft: p.turn, Potions: p.potions}
+		if p.curPokemon != nil {
+			side.Pokemon = p.curPokemon.Name
+			side.Level = p.curPokemon.Level
+			side.HP = p.curPokemon.HP
+			side.MaxHP = p.curPokemon.MaxHP
+		}
+		status.Sides = append(status.Sides, side)
+	}
+	return status
+}
+
+// distributeExp shares a third of the loser's total base experience with
+// every Pokemon of the winner and returns the level-up announcements.
+func distributeExp(battle int, winner, loser *Participant) []string {
+	totalExp := 0
+	for _, pokemon := range loser.player.PokemonList {
+		if species := pokemon.Species(); species != nil {
+			totalExp += species.Exp
+		}
+	}
+
+	// Distribute the total experience to the winning team
+	expPerPokemon := totalExp / 3
+	var levelUps []string
+	for _, pokemon := range winner.player.PokemonList {
+		before := pokemon.Level
+		levelUps = append(levelUps, pokemon.gainExp(expPerPokemon)...)
+		audit.Info("exp", "battle", battle, "player", winner.player.Name, "pokemon", pokemon.Name, "pokemon_id", pokemon.ID,
+			"exp", expPerPokemon, "level_before", before, "level", pokemon.Level)
+	}
+	return levelUps
+}
+func announceLevelUps(winner *Participant, levelUps []string) {
+	if len(levelUps) == 0 {
+		return
+	}
+	winner.send(battleEvent("\n" + strings.Join(levelUps, "")))
+}
+
+// resolveTurn applies both actions: forfeits first, then switches and items,
+// then moves in priority and speed order. It returns a winner only when
+// someone forfeited; fainted Pokemon are handled by the caller.
+func (s *BattleSession) resolveTurn(participant1, participant2 *Participant, action1, action2 battleAction) (*Participant, *Participant) {
+	if action1.kind == "forfeit" {
+		return participant2, participant1
+	}
+	if action2.kind == "forfeit" {
+		return participant1, participant2
+	}
+	s.log = append(s.log, "------------BATTLE REPORT------------\n")
+	useItemOrSwitch(&s.log, participant1, action1)
+	useItemOrSwitch(&s.log, participant2, action2)
+
+	switch {
+	case action1.kind == "move" && action2.kind == "move":
+		attacker, defender := turnOrder(participant1, participant2, slotMove(action1.slot), slotMove(action2.slot))
+		attackerSlot, defenderSlot := action1.slot, action2.slot
+		if attacker == participant2 {
+			attackerSlot, defenderSlot = action2.slot, action1.slot
+		}
+		if !s.useMove(attacker, defender, attackerSlot) {
+			s.useMove(defender, attacker, defenderSlot)
+		}
+	case action1.kind == "move":
+		s.useMove(participant1, participant2, action1.slot)
+	case action2.kind == "move":
+		s.useMove(participant2, participant1, action2.slot)
+	}
+
+	for _, p := range []*Participant{participant1, participant2} {
+		s.log = append(s.log, fmt.Sprintf("➜ %s has %d/%d HP left.\n", p.curPokemon.Name, p.curPokemon.HP, p.curPokemon.MaxHP))
+	}
+	s.log = append(s.log, "------END BATTLE REPORT-----\n")
+	return nil, nil
+}
+
+// useItemOrSwitch applies a switch or Potion, which go before any move.
+func useItemOrSwitch(log *[]string, self *Participant, action battleAction) {
+	switch action.kind {
+	case "switch":
+		*log = append(*log, fmt.Sprintf("%s withdrew %s and sent out %s!\n", self.player.Name, self.curPokemon.Name, action.pokemon.Name))
+		self.sendOut(action.pokemon)
+	case "item":
+		healed := min(cfg.Battle.PotionHeal, self.curPokemon.MaxHP-self.curPokemon.HP)
+		self.curPokemon.HP += healed
+		self.potions--
+		*log = append(*log, fmt.Sprintf("%s used a Potion, %s recovered %d HP.\n", self.player.Name, self.curPokemon.Name, healed))
+	}
+}
+
+// useMove lets attacker use the move in slot (nil for Struggle) on defender
+// and reports whether the defender fainted, which costs its player a turn.
+func (s *BattleSession) useMove(attacker, defender *Participant, slot *MoveSlot) bool {
+	if !strike(&s.log, attacker, defender, slot) {
+		return false
+	}
+	defender.turn--
+	s.log = append(s.log, fmt.Sprintf("➪ %s has %d turns left.\n", defender.player.Name, defender.turn))
+	return true
+}
+
+// strike lets attacker use the move in slot (nil for Struggle) on defender,
+// adds what happened to log and reports whether the defender fainted.
+func strike(log *[]string, attacker, defender *Participant, slot *MoveSlot) bool {
+	move := slotMove(slot)
+	if slot != nil {
+		slot.PP--
+	}
+	*log = append(*log, fmt.Sprintf("%s used %s!\n", attacker.curPokemon.Name, move.Name))
+	result := calculateDamage(attacker, defender, move)
+	if result.missed {
+		*log = append(*log, fmt.Sprintf("%s's attack missed!\n", attacker.curPokemon.Name))
+		return false
+	}
+	if move.Category == "status" {
+		*log = append(*log, applyStatus(attacker, defender, move))
+		return false
+	}
+	defender.curPokemon.HP -= result.damage
+	slog.Debug("attack", "attacker", attacker.curPokemon.Name, "defender", defender.curPokemon.Name, "move", move.Name, "damage", result.damage)
+	if result.critical {
+		*log = append(*log, "A critical hit!\n")
+	}
+	if note := effectivenessMessage(result.effectiveness, defender.curPokemon.Name); note != "" {
+		*log = append(*log, note)
+	}
+	if result.effectiveness != 0 {
+		*log = append(*log, fmt.Sprintf("%s took %d damage.\n", defender.curPokemon.Name, result.damage))
+	}
+	if defender.curPokemon.HP > 0 {
+		return false
+	}
+	*log = append(*log, fmt.Sprintf("➜ %s fainted.\n", defender.curPokemon.Name))
+	// the current Pokemon is the roster instance itself, so the
+	// winner keeps its remaining HP
+	defender.curPokemon.HP = 0
+	defender.curPokemon.Deployable = false
+	return true
+}
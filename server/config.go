@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	envPrefix      = "POKEGAME"
+	pokedexFile    = "pokedex.json"
+	playersFile    = "players.json"
+	bansFile       = "bans.json"
+	auditFile      = "audit.log"
+	journalDirName = "journal"
+	evolutionsFile = "evolutions.json"
+	typeChartFile  = "types.json"
+	movesFile      = "moves.json"
+	biomesFile     = "biomes.json"
+	tlsCertFile    = "tls/server.crt"
+	tlsKeyFile     = "tls/server.key"
+	maxWorldSize   = 1000
+	maxViewport    = 101
+)
+
+// Config holds every setting of the server. It is read from the file named
+// by -config, then POKEGAME_* environment variables, then flags.
+type Config struct {
+	Listen          string          `toml:"listen"`
+	HTTP            string          `toml:"http"`  // browser client and /ws, off when empty
+	Admin           string          `toml:"admin"` // loopback only, off when empty
+	DataDir         string          `toml:"data_dir"`
+	Store           string          `toml:"store"`
+	JournalDir      string          `toml:"journal_dir"` // defaults to <data_dir>/journal
+	Matchmaking     string          `toml:"matchmaking"`
+	ReconnectGrace  time.Duration   `toml:"reconnect_grace"`
+	ShutdownTimeout time.Duration   `toml:"shutdown_timeout"` // deadline for a graceful stop
+	TLS             TLSConfig       `toml:"tls"`
+	World           WorldConfig     `toml:"world"`
+	Encounter       EncounterConfig `toml:"encounter"`
+	Team            TeamConfig      `toml:"team"`
+	Battle          BattleConfig    `toml:"battle"`
+	Log             LogConfig       `toml:"log"`
+}
+
+type LogConfig struct {
+	Level  string `toml:"level"`  // debug, info, warn or error
+	Format string `toml:"format"` // text or json
+	Audit  string `toml:"audit"`  // defaults to <data_dir>/audit.log, "off" turns it off
+}
+
+type TLSConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Cert    string `toml:"cert"` // defaults to <data_dir>/tls/server.crt
+	Key     string `toml:"key"`  // defaults to <data_dir>/tls/server.key
+}
+
+type WorldConfig struct {
+	Size            int           `toml:"size"`
+	Viewport        int           `toml:"viewport"` // tiles a player sees across, up to size
+	Map             string        `toml:"map"`      // terrain file, generated from Seed when empty
+	Seed            int64         `toml:"seed"`     // 0 picks one at random
+	SpawnInterval   time.Duration `toml:"spawn_interval"`
+	DespawnAfter    time.Duration `toml:"despawn_after"`
+	PokemonPerSpawn int           `toml:"pokemon_per_spawn"`
+	WildLevelMax    int           `toml:"wild_level_max"`
+}
+
+// EncounterConfig sets up the wild battles that start when a catcher walks
+// into a Pokemon. Balls are handed out anew for every encounter.
+type EncounterConfig struct {
+	PokeBalls  int     `toml:"poke_balls"`
+	GreatBalls int     `toml:"great_balls"`
+	UltraBalls int     `toml:"ultra_balls"`
+	FleeChance float64 `toml:"flee_chance"` // per turn for a common Pokemon, rarer ones flee more often
+}
+
+type TeamConfig struct {
+	MaxPokemon int `toml:"max_pokemon"`
+}
+
+type BattleConfig struct {
+	TurnTimeout time.Duration `toml:"turn_timeout"`
+	Faints      int           `toml:"faints"` // fainted Pokemon a player can afford before losing
+	Potions     int           `toml:"potions"`
+	PotionHeal  int           `toml:"potion_heal"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Listen:          ":3015",
+		Admin:           "127.0.0.1:3016",
+		DataDir:         "./Assets",
+		Store:           "json",
+		Matchmaking:     "rating",
+		ReconnectGrace:  60 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+		World: WorldConfig{
+			Size:            512,
+			Viewport:        21,
+			SpawnInterval:   5 * time.Second,
+			DespawnAfter:    10 * time.Second,
+			PokemonPerSpawn: 20,
+			WildLevelMax:    5,
+		},
+		Encounter: EncounterConfig{
+			PokeBalls:  5,
+			GreatBalls: 2,
+			UltraBalls: 1,
+			FleeChance: 0.08,
+		},
+		Team: TeamConfig{MaxPokemon: 10},
+		Battle: BattleConfig{
+			TurnTimeout: 30 * time.Second,
+			Faints:      3,
+			Potions:     2,
+			PotionHeal:  20,
+		},
+		Log: LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// bindFlags registers a flag for every setting, defaulting to the current
+// value of c.
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "addr", c.Listen, "address the game listens on")
+	fs.StringVar(&c.HTTP, "http", c.HTTP, "address of the HTTP server with the browser client, off when empty")
+	fs.StringVar(&c.Admin, "admin", c.Admin, "loopback address of the admin console, off when empty")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory with the pokedex, game data and players")
+	fs.StringVar(&c.Store, "store", c.Store, "player store backend: json or journal")
+	fs.StringVar(&c.JournalDir, "journal-dir", c.JournalDir, "directory of the journal store (default <data-dir>/journal)")
+	fs.StringVar(&c.Matchmaking, "matchmaking", c.Matchmaking, "how to pair battle players: rating or fifo")
+	fs.DurationVar(&c.ReconnectGrace, "grace", c.ReconnectGrace, "how long a dropped player keeps its place before it is removed")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long a SIGINT or SIGTERM waits for battles and clients before the server stops")
+	fs.BoolVar(&c.TLS.Enabled, "tls", c.TLS.Enabled, "serve the game over TLS instead of plain TCP")
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert, "TLS certificate, generated self-signed if it and the key are missing (default <data-dir>/tls/server.crt)")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key, "TLS private key (default <data-dir>/tls/server.key)")
+	fs.IntVar(&c.World.Size, "world-size", c.World.Size, "width and height of the catch-mode world")
+	fs.IntVar(&c.World.Viewport, "viewport", c.World.Viewport, "width and height of the part of the world a player sees")
+	fs.StringVar(&c.World.Map, "world-map", c.World.Map, "terrain file of the world, one letter per tile, generated when empty")
+	fs.Int64Var(&c.World.Seed, "world-seed", c.World.Seed, "seed of the generated terrain, random when 0")
+	fs.DurationVar(&c.World.SpawnInterval, "spawn-interval", c.World.SpawnInterval, "time between two waves of wild Pokemon")
+	fs.DurationVar(&c.World.DespawnAfter, "despawn-after", c.World.DespawnAfter, "how long a wild Pokemon stays")
+	fs.IntVar(&c.World.PokemonPerSpawn, "pokemon-per-spawn", c.World.PokemonPerSpawn, "wild Pokemon per wave")
+	fs.IntVar(&c.World.WildLevelMax, "wild-level-max", c.World.WildLevelMax, "highest level of a wild Pokemon")
+	fs.IntVar(&c.Encounter.PokeBalls, "poke-balls", c.Encounter.PokeBalls, "Poke Balls per wild encounter")
+	fs.IntVar(&c.Encounter.GreatBalls, "great-balls", c.Encounter.GreatBalls, "Great Balls per wild encounter")
+	fs.IntVar(&c.Encounter.UltraBalls, "ultra-balls", c.Encounter.UltraBalls, "Ultra Balls per wild encounter")
+	fs.Float64Var(&c.Encounter.FleeChance, "flee-chance", c.Encounter.FleeChance, "chance per turn that a common wild Pokemon flees, rarer ones flee more often")
+	fs.IntVar(&c.Team.MaxPokemon, "max-pokemon", c.Team.MaxPokemon, "most Pokemon a player can own")
+	fs.DurationVar(&c.Battle.TurnTimeout, "turn-timeout", c.Battle.TurnTimeout, "time to choose an action in a battle")
+	fs.IntVar(&c.Battle.Faints, "faints", c.Battle.Faints, "fainted Pokemon a player can afford before losing a battle")
+	fs.IntVar(&c.Battle.Potions, "potions", c.Battle.Potions, "Potions per player and battle")
+	fs.IntVar(&c.Battle.PotionHeal, "potion-heal", c.Battle.PotionHeal, "HP healed by a Potion")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "least severe log level shown: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: text or json")
+	fs.StringVar(&c.Log.Audit, "audit-log", c.Log.Audit, `file of the gameplay audit log, "off" turns it off (default <data-dir>/audit.log)`)
+}
+
+// resolve fills the paths that default to the data directory.
+func (c *Config) resolve() {
+	if c.JournalDir == "" {
+		c.JournalDir = c.dataPath(journalDirName)
+	}
+	if c.Log.Audit == "" {
+		c.Log.Audit = c.dataPath(auditFile)
+	}
+	if c.TLS.Cert == "" {
+		c.TLS.Cert = c.dataPath(tlsCertFile)
+	}
+	if c.TLS.Key == "" {
+		c.TLS.Key = c.dataPath(tlsKeyFile)
+	}
+}
+
+func (c *Config) dataPath(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
+
+// validate reports every invalid setting at once.
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listen != "", "listen must not be empty")
+	if c.Admin != "" {
+		check(isLoopback(c.Admin), "admin must be a loopback address like 127.0.0.1:3016, got %q", c.Admin)
+	}
+	if info, err := os.Stat(c.DataDir); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("data_dir %q is not a directory", c.DataDir))
+	} else {
+		for _, name := range []string{pokedexFile, evolutionsFile, typeChartFile, movesFile, biomesFile} {
+			_, err := os.Stat(c.dataPath(name))
+			check(err == nil, "data_dir %q has no %s", c.DataDir, name)
+		}
+	}
+	check(c.Store == "json" || c.Store == "journal", "store must be json or journal, got %q", c.Store)
+	check(c.Matchmaking == "rating" || c.Matchmaking == "fifo", "matchmaking must be rating or fifo, got %q", c.Matchmaking)
+	check(c.ReconnectGrace >= 0, "reconnect_grace must not be negative, got %s", c.ReconnectGrace)
+	check(c.ShutdownTimeout >= time.Second, "shutdown_timeout must be at least 1s, got %s", c.ShutdownTimeout)
+	check(c.World.Size >= 5 && c.World.Size <= maxWorldSize, "world.size must be between 5 and %d, got %d", maxWorldSize, c.World.Size)
+	check(c.World.Viewport >= 5 && c.World.Viewport <= maxViewport, "world.viewport must be between 5 and %d, got %d", maxViewport, c.World.Viewport)
+	if c.World.Map != "" {
+		_, err := os.Stat(c.World.Map)
+		check(err == nil, "world.map %q does not exist", c.World.Map)
+	}
+	check(c.World.SpawnInterval >= time.Second, "world.spawn_interval must be at least 1s, got %s", c.World.SpawnInterval)
+	// wild Pokemon are removed a little before their time is up
+	check(c.World.DespawnAfter > 2*time.Second, "world.despawn_after must be longer than 2s, got %s", c.World.DespawnAfter)
+	check(c.World.PokemonPerSpawn >= 0 && c.World.PokemonPerSpawn <= c.World.Size*c.World.Size/2,
+		"world.pokemon_per_spawn must be between 0 and half the tiles (%d), got %d", c.World.Size*c.World.Size/2, c.World.PokemonPerSpawn)
+	check(c.World.WildLevelMax >= 1 && c.World.WildLevelMax <= maxLevel, "world.wild_level_max must be between 1 and %d, got %d", maxLevel, c.World.WildLevelMax)
+	check(c.Encounter.PokeBalls >= 0 && c.Encounter.GreatBalls >= 0 && c.Encounter.UltraBalls >= 0,
+		"encounter.poke_balls, great_balls and ultra_balls must not be negative")
+	// legendary Pokemon flee three times as often as common ones
+	check(c.Encounter.FleeChance >= 0 && c.Encounter.FleeChance*fleeFactors["legendary"] < 1, "encounter.flee_chance must be at least 0 and below 1/3, got %g", c.Encounter.FleeChance)
+	check(c.Team.MaxPokemon >= len(starters), "team.max_pokemon must be at least %d to hold the starters, got %d", len(starters), c.Team.MaxPokemon)
+	check(c.Battle.TurnTimeout >= time.Second, "battle.turn_timeout must be at least 1s, got %s", c.Battle.TurnTimeout)
+	check(c.Battle.Faints >= 1, "battle.faints must be at least 1, got %d", c.Battle.Faints)
+	check(c.Battle.Potions >= 0, "battle.potions must not be negative, got %d", c.Battle.Potions)
+	check(c.Battle.PotionHeal >= 1, "battle.potion_heal must be at least 1, got %d", c.Battle.PotionHeal)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+	return errors.Join(errs...)
+}
+
+// isLoopback reports whether addr only listens on this machine.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
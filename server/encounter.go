@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ball is a kind of Poke Ball; bonus multiplies the chance to catch.
+type ball struct {
+	key   string // what the player types to throw it
+	name  string
+	bonus float64
+}
+
+var balls = []ball{
+	{"b", "Poke Ball", 1},
+	{"g", "Great Ball", 1.5},
+	{"u", "Ultra Ball", 2},
+}
+
+// catchRates are out of 255 as in the games: a common Pokemon at full HP is
+// caught by one Poke Ball in four to eight, a legendary one hardly ever.
+var catchRates = map[string]float64{
+	"common":    190,
+	"uncommon":  120,
+	"rare":      45,
+	"legendary": 3,
+}
+
+// fleeFactors scale cfg.Encounter.FleeChance by rarity. validate keeps the
+// legendary chance below 1, so no Pokemon flees on its first turn for sure.
+var fleeFactors = map[string]float64{
+	"common":    1,
+	"uncommon":  1.5,
+	"rare":      2,
+	"legendary": 3,
+}
+
+// catchChance is the chance that b holds wild: the catch rate times the
+// ball bonus, times an HP factor that grows from 1/3 at full HP to 1 at 0,
+// times 1.5 minus the EV multiplier, so a wild individual (EVs 0.5 to 1)
+// keeps half to all of that and a stronger one fights the ball harder.
+func catchChance(wild *OwnedPokemon, b ball) float64 {
+	hp := float64(3*wild.MaxHP-2*max(wild.HP, 0)) / float64(3*wild.MaxHP)
+	rate := catchRates[rarityOf(wild.Species())] / 255
+	return min(math.Max(hp*rate*b.bonus*(1.5-wild.EVPoints), 0), 1)
+}
+
+func fleeChance(wild *OwnedPokemon) float64 {
+	return cfg.Encounter.FleeChance * fleeFactors[rarityOf(wild.Species())]
+}
+
+// encounter is a catcher's wild battle with a Pokemon they walked into. It
+// runs on its own goroutine and reads the lines that the catch-mode reader
+// passes on through input; the reader closes input when the player leaves.
+type encounter struct {
+	self     *Participant
+	wild     *Participant // the wild Pokemon as the other side
+	pokemon  *OwnedPokemon
+	balls    map[string]int // by key
+	input    chan string
+	turn     int
+	log      []string
+	started  time.Time
+	finished chan struct{}
+}
+
+// startEncounter sets p on pokemon, which the world has reserved for it.
+// It is called by the reader of p's session.
+func startEncounter(p *Participant, pokemon *OwnedPokemon) *encounter {
+	e := &encounter{
+		self:     p,
+		wild:     &Participant{player: &Player{Name: "the wild " + pokemon.Name}, stages: map[string]int{}, curPokemon: pokemon},
+		pokemon:  pokemon,
+		balls:    map[string]int{"b": cfg.Encounter.PokeBalls, "g": cfg.Encounter.GreatBalls, "u": cfg.Encounter.UltraBalls},
+		input:    make(chan string),
+		started:  time.Now(),
+		finished: make(chan struct{}),
+	}
+	p.input = e.input
+	p.potions = cfg.Battle.Potions
+	battlesDone.Add(1)
+	go e.run()
+	return e
+}
+
+// feed passes a line from the player on, unless the encounter is over.
+func (e *encounter) feed(line string) {
+	select {
+	case e.input <- line:
+	case <-e.finished:
+	}
+}
+
+// leave tells the encounter that the player is gone. Only the reader that
+// feeds it may call it, once.
+func (e *encounter) leave() {
+	close(e.input)
+}
+
+func (e *encounter) over() bool {
+	select {
+	case <-e.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *encounter) run() {
+	defer battlesDone.Done()
+	defer close(e.finished)
+	player := e.self.player
+	audit.Info("encounter_start", "player", player.Name, "pokemon", e.pokemon.Name, "pokemon_id", e.pokemon.ID,
+		"level", e.pokemon.Level, "rarity", rarityOf(e.pokemon.Species()))
+	outcome := e.fight()
+	e.flush()
+	audit.Info("encounter_end", "player", player.Name, "pokemon", e.pokemon.Name, "pokemon_id", e.pokemon.ID,
+		"outcome", outcome, "turns", e.turn, "duration", time.Since(e.started).Round(time.Millisecond).String())
+	encounters.inc(outcome)
+	switch outcome {
+	case "caught":
+		world.capture(player, e.pokemon)
+	case "fled", "fainted":
+		world.release(e.pokemon, true)
+		pokemonDespawned.inc(outcome)
+	default:
+		world.release(e.pokemon, false)
+	}
+	switch outcome {
+	case "fainted", "fled", "ran":
+		// keep the EXP and levels won in the fight
+		world.savePlayer(player)
+	}
+	if outcome == "lost" {
+		world.mux.Lock()
+		for _, p := range player.PokemonList {
+			p.heal()
+		}
+		world.mux.Unlock()
+		e.self.send(battleEvent("You hurried to the nearest Pokémon Center, your team is healed.\n"))
+	}
+	// back to the map, unless the player has left it
+	world.watch(e.self)
+}
+
+// fight plays turns until the encounter is decided and returns how it
+// ended: caught, fled, fainted, ran, lost or left.
+func (e *encounter) fight() string {
+	self, wild := e.self, e.wild
+	world.mux.Lock()
+	if deployable := deployablePokemon(self.player); len(deployable) > 0 {
+		self.sendOut(deployable[0])
+	} else {
+		self.curPokemon = nil
+	}
+	world.mux.Unlock()
+	e.logf("\n🌿 A wild %s appeared! Lv %d, HP %d/%d, %s, EV x%.2f\n",
+		wild.curPokemon.Name, wild.curPokemon.Level, wild.curPokemon.HP, wild.curPokemon.MaxHP, rarityOf(e.pokemon.Species()), e.pokemon.EVPoints)
+	if self.curPokemon != nil {
+		e.logf("Go, %s!\n", self.curPokemon.Name)
+	}
+	e.flush()
+
+	for e.turn = 1; ; e.turn++ {
+		action := e.readAction()
+		switch action.kind {
+		case "leave":
+			return "left"
+		case "suspend":
+			e.logf("\n⏸ The server is shutting down, %s got away.\n", wild.player.Name)
+			return "left"
+		case "run":
+			e.logf("You got away safely.\n")
+			return "ran"
+		}
+		world.mux.Lock()
+		outcome := e.resolve(action)
+		world.mux.Unlock()
+		e.flush()
+		if outcome != "" {
+			return outcome
+		}
+		if self.curPokemon == nil || self.curPokemon.HP > 0 {
+			continue
+		}
+		if len(deployablePokemon(self.player)) == 0 {
+			e.logf("You have no Pokémon left that can fight!\n")
+			return "lost"
+		}
+		pokemonList := getListOfPokemon(self.player.PokemonList)
+		next, ran := choosePokemon(self, "\nYour Pokemon fainted, Let's choose another Pokemon\n"+pokemonList+"PRESS -1 to run away - Your choice: ", cfg.Battle.TurnTimeout)
+		// a player who left also counts as running here
+		switch {
+		case ran:
+			return "ran"
+		case next == nil:
+			return "left"
+		}
+		self.sendOut(next)
+		e.logf("Go, %s!\n", next.Name)
+		e.flush()
+	}
+}
+
+// resolve plays one turn; the caller holds world.mux. Throwing a ball,
+// switching or using a Potion takes the turn, and the wild Pokemon attacks;
+// a move is traded with it in speed order. It returns the outcome once the
+// encounter is decided.
+func (e *encounter) resolve(action battleAction) string {
+	self, wild := e.self, e.wild
+	wildSlot := chooseMove(wild.curPokemon)
+	switch action.kind {
+	case "ball":
+		e.balls[action.ball.key]--
+		e.logf("%s threw a %s!\n", self.player.Name, action.ball.name)
+		if rand.Float64() < catchChance(e.pokemon, *action.ball) {
+			e.logf("Gotcha! %s was caught!\n", e.pokemon.Name)
+			return "caught"
+		}
+		e.logf("Oh no! %s broke free!\n", e.pokemon.Name)
+	default:
+		useItemOrSwitch(&e.log, self, action)
+	}
+	if action.kind == "move" {
+		first, second := turnOrder(self, wild, slotMove(action.slot), slotMove(wildSlot))
+		slots := map[*Participant]*MoveSlot{self: action.slot, wild: wildSlot}
+		if !strike(&e.log, first, second, slots[first]) {
+			strike(&e.log, second, first, slots[second])
+		}
+	} else if self.curPokemon != nil {
+		strike(&e.log, wild, self, wildSlot)
+	}
+	if wild.curPokemon.HP <= 0 {
+		if self.curPokemon != nil && self.curPokemon.HP > 0 {
+			// the base experience times the level over 7, as in the games
+			exp := e.pokemon.Species().Exp * e.pokemon.Level / 7
+			before := self.curPokemon.Level
+			e.log = append(e.log, self.curPokemon.gainExp(exp)...)
+			audit.Info("exp", "player", self.player.Name, "pokemon", self.curPokemon.Name, "pokemon_id", self.curPokemon.ID,
+				"exp", exp, "level_before", before, "level", self.curPokemon.Level)
+		}
+		return "fainted"
+	}
+	for _, p := range []*Participant{self, wild} {
+		if p.curPokemon != nil {
+			e.logf("➜ %s has %d/%d HP left.\n", p.curPokemon.Name, max(p.curPokemon.HP, 0), p.curPokemon.MaxHP)
+		}
+	}
+	if rand.Float64() < fleeChance(e.pokemon) {
+		e.logf("The wild %s fled!\n", e.pokemon.Name)
+		return "fled"
+	}
+	return ""
+}
+
+// readAction waits for the player's next action. A player who lets the turn
+// time run out runs away, so a wild Pokemon is not held forever.
+func (e *encounter) readAction() battleAction {
+	self := e.self
+	world.mux.Lock()
+	prompt := e.prompt()
+	world.mux.Unlock()
+	self.prompt(prompt)
+	defer self.clearPrompt()
+	deadline := time.After(cfg.Battle.TurnTimeout)
+	for {
+		select {
+		case line, ok := <-e.input:
+			if !ok {
+				return battleAction{kind: "leave"}
+			}
+			if handleCommand(self, line) {
+				continue
+			}
+			action, err := e.parseAction(line)
+			if err != nil {
+				self.send(battlePrompt(err.Error() + "\nYour action: "))
+				continue
+			}
+			return action
+		case <-deadline:
+			self.send(battleEvent("\n⏰ Time is up, you ran away.\n"))
+			return battleAction{kind: "run"}
+		case <-stopping:
+			return battleAction{kind: "suspend"}
+		}
+	}
+}
+
+func (e *encounter) prompt() string {
+	self, wild := e.self, e.wild.curPokemon
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n------------WILD BATTLE, TURN %d------------\n", e.turn)
+	if self.curPokemon != nil {
+		fmt.Fprintf(&b, "Your %s (Lv %d) HP %d/%d vs the wild %s (Lv %d) HP %d/%d\n",
+			self.curPokemon.Name, self.curPokemon.Level, self.curPokemon.HP, self.curPokemon.MaxHP,
+			wild.Name, wild.Level, wild.HP, wild.MaxHP)
+		writeOptions(&b, self)
+	} else {
+		fmt.Fprintf(&b, "The wild %s (Lv %d) HP %d/%d. None of your Pokémon can fight.\n", wild.Name, wild.Level, wild.HP, wild.MaxHP)
+	}
+	for _, bl := range balls {
+		if n := e.balls[bl.key]; n > 0 {
+			fmt.Fprintf(&b, "%s. throw a %s (%d left, %.0f%% to catch)\n", bl.key, bl.name, n, 100*catchChance(e.pokemon, bl))
+		}
+	}
+	b.WriteString("r. run away\n")
+	fmt.Fprintf(&b, "Your action (%ds): ", int(cfg.Battle.TurnTimeout.Seconds()))
+	return b.String()
+}
+
+func (e *encounter) parseAction(line string) (battleAction, error) {
+	fields := strings.Fields(strings.ToLower(line))
+	if len(fields) > 0 {
+		switch fields[0] {
+		case "r", "run", "f", "forfeit":
+			return battleAction{kind: "run"}, nil
+		}
+		for i := range balls {
+			if fields[0] != balls[i].key {
+				continue
+			}
+			if e.balls[balls[i].key] <= 0 {
+				return battleAction{}, fmt.Errorf("You have no %ss left.", balls[i].name)
+			}
+			return battleAction{kind: "ball", ball: &balls[i]}, nil
+		}
+	}
+	if e.self.curPokemon == nil {
+		return battleAction{}, fmt.Errorf("None of your Pokémon can fight, throw a ball or run.")
+	}
+	world.mux.Lock()
+	defer world.mux.Unlock()
+	return parseAction(e.self, line)
+}
+
+func (e *encounter) logf(format string, args ...any) {
+	e.log = append(e.log, fmt.Sprintf(format, args...))
+}
+
+// flush sends what happened since the last flush.
+func (e *encounter) flush() {
+	if len(e.log) == 0 {
+		return
+	}
+	e.self.send(battleEvent(strings.Join(e.log, "")))
+	e.log = nil
+}
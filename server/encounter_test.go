@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestCatchChance(t *testing.T) {
+	common := &Pokemon{Name: "Pidgey", Exp: 50}
+	legendary := &Pokemon{Name: "Mewtwo", Exp: 300}
+	wild := func(species *Pokemon, hp int, ev float64) *OwnedPokemon {
+		return &OwnedPokemon{Name: species.Name, HP: hp, MaxHP: 60, EVPoints: ev, species: species}
+	}
+	pokeBall, ultraBall := balls[0], balls[2]
+	tests := []struct {
+		name     string
+		wild     *OwnedPokemon
+		ball     ball
+		min, max float64
+	}{
+		{"common at full HP", wild(common, 60, 1), pokeBall, 0.12, 0.13},
+		{"common at full HP, weak EVs", wild(common, 60, 0.5), pokeBall, 0.24, 0.25},
+		{"common at 1 HP", wild(common, 1, 1), pokeBall, 0.36, 0.38},
+		{"common at 0 HP in an Ultra Ball", wild(common, 0, 0.5), ultraBall, 1, 1},
+		{"HP below 0 counts as 0", wild(common, -10, 0.5), ultraBall, 1, 1},
+		{"legendary at full HP", wild(legendary, 60, 1), pokeBall, 0.001, 0.003},
+		{"legendary at 0 HP in an Ultra Ball", wild(legendary, 0, 0.5), ultraBall, 0.02, 0.03},
+		{"EVs past 1.5 never go below 0", wild(common, 0, 2), ultraBall, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := catchChance(tt.wild, tt.ball); got < tt.min || got > tt.max {
+			t.Errorf("%s: chance %.4f, want between %g and %g", tt.name, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestCatchChanceGrowsAsHPDrops(t *testing.T) {
+	species := &Pokemon{Name: "Rattata", Exp: 120}
+	for _, b := range balls {
+		last := -1.0
+		for hp := 60; hp >= 0; hp -= 10 {
+			got := catchChance(&OwnedPokemon{HP: hp, MaxHP: 60, EVPoints: 0.75, species: species}, b)
+			if got < last || got < 0 || got > 1 {
+				t.Fatalf("%s at %d HP: chance %.4f after %.4f", b.name, hp, got, last)
+			}
+			last = got
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"embed"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// web holds the browser client.
+//
+//go:embed web
+var web embed.FS
+
+// startHTTP serves the browser client, its WebSocket gateway, the
+// read-only API and the metrics on addr, over TLS when tlsConfig is set.
+func startHTTP(addr string, tlsConfig *tls.Config) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	static, err := fs.Sub(web, "web")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(static)))
+	mux.HandleFunc("GET /ws", serveWebSocket)
+	registerAPI(mux)
+	mux.HandleFunc("GET /metrics", serveMetrics)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "err", err)
+		}
+	}()
+	slog.Info("HTTP listening", "addr", listener.Addr().String())
+	return server, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	journalSnapshotFile = "snapshot.json"
+	journalLogFile      = "journal.log"
+	journalCompactEvery = 500
+)
+
+// journalRecord is one line of the write-ahead journal. Every record carries
+// the full player so replaying it twice gives the same roster.
+type journalRecord struct {
+	Op     string  `json:"op"`
+	Name   string  `json:"name"`
+	Player *Player `json:"player,omitempty"`
+}
+
+// compactor is implemented by stores that can fold their history into a snapshot.
+type compactor interface {
+	Compact() error
+}
+
+// journalPlayerRepository appends every change to journal.log (fsynced before
+// Save returns) and periodically folds it into snapshot.json.
+type journalPlayerRepository struct {
+	dir      string
+	seedPath string
+	mu       sync.Mutex
+	players  map[string]*Player
+	order    []string
+	journal  *os.File
+	entries  int
+}
+
+// newJournalPlayerRepository stores its files in dir. If the directory holds
+// no data yet, the players in seedPath (a players.json file) are imported.
+func newJournalPlayerRepository(dir, seedPath string) *journalPlayerRepository {
+	return &journalPlayerRepository{
+		dir:      dir,
+		seedPath: seedPath,
+		players:  make(map[string]*Player),
+	}
+}
+
+func (r *journalPlayerRepository) snapshotPath() string {
+	return filepath.Join(r.dir, journalSnapshotFile)
+}
+
+func (r *journalPlayerRepository) logPath() string {
+	return filepath.Join(r.dir, journalLogFile)
+}
+
+func (r *journalPlayerRepository) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return fmt.Errorf("create journal dir: %w", err)
+	}
+	if r.journal != nil {
+		r.journal.Close()
+		r.journal = nil
+	}
+	r.players = make(map[string]*Player)
+	r.order = nil
+	r.entries = 0
+
+	_, snapErr := os.Stat(r.snapshotPath())
+	_, logErr := os.Stat(r.logPath())
+	if os.IsNotExist(snapErr) && os.IsNotExist(logErr) && r.seedPath != "" {
+		if err := r.importSeed(); err != nil {
+			return err
+		}
+	} else if err := r.loadSnapshot(); err != nil {
+		return err
+	}
+
+	goodSize, err := r.replay()
+	if err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(r.logPath(), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("open journal: %w", err)
+	}
+	// drop a torn record left behind by a crash mid-write
+	if err := journal.Truncate(goodSize); err != nil {
+		journal.Close()
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	if _, err := journal.Seek(goodSize, io.SeekStart); err != nil {
+		journal.Close()
+		return fmt.Errorf("seek journal: %w", err)
+	}
+	r.journal = journal
+	return nil
+}
+
+func (r *journalPlayerRepository) importSeed() error {
+	seed := newJSONPlayerRepository(r.seedPath)
+	if err := seed.Load(); err != nil {
+		return fmt.Errorf("import %s: %w", r.seedPath, err)
+	}
+	for _, p := range seed.List() {
+		r.put(p)
+	}
+	return r.writeSnapshot()
+}
+
+func (r *journalPlayerRepository) loadSnapshot() error {
+	data, err := os.ReadFile(r.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	list := []*Player{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	for _, p := range list {
+		if p != nil && playerKey(p.Name) != "" {
+			r.put(p)
+		}
+	}
+	return nil
+}
+
+// replay applies every intact journal record and returns the offset just
+// past the last one.
+func (r *journalPlayerRepository) replay() (int64, error) {
+	file, err := os.Open(r.logPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read journal: %w", err)
+		}
+		record, ok := decodeJournalLine(line)
+		if !ok {
+			return offset, nil
+		}
+		switch record.Op {
+		case "put":
+			r.put(record.Player)
+		case "delete":
+			r.remove(record.Name)
+		}
+		r.entries++
+		offset += int64(len(line))
+	}
+}
+
+// journal lines look like "<crc32 hex> <json>\n"
+func encodeJournalLine(record journalRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))
+	return append(append([]byte(line), data...), '\n'), nil
+}
+
+func decodeJournalLine(line []byte) (journalRecord, bool) {
+	var record journalRecord
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, data, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return record, false
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || crc32.ChecksumIEEE(data) != uint32(want) {
+		return record, false
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, false
+	}
+	if record.Op == "put" && (record.Player == nil || playerKey(record.Player.Name) == "") {
+		return record, false
+	}
+	return record, true
+}
+
+func (r *journalPlayerRepository) put(p *Player) {
+	key := playerKey(p.Name)
+	if _, ok := r.players[key]; !ok {
+		r.order = append(r.order, key)
+	}
+	r.players[key] = clonePlayer(p)
+}
+
+func (r *journalPlayerRepository) remove(name string) {
+	key := playerKey(name)
+	if _, ok := r.players[key]; !ok {
+		return
+	}
+	delete(r.players, key)
+	r.order = removeKey(r.order, key)
+}
+
+// append must be called with r.mu held.
+func (r *journalPlayerRepository) append(record journalRecord) error {
+	if r.journal == nil {
+		return fmt.Errorf("journal is not open")
+	}
+	line, err := encodeJournalLine(record)
+	if err != nil {
+		return fmt.Errorf("encode journal record: %w", err)
+	}
+	if _, err := r.journal.Write(line); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := r.journal.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	r.entries++
+	return nil
+}
+
+func (r *journalPlayerRepository) Save(player *Player) (err error) {
+	defer func(start time.Time) { observeSave(start, err) }(time.Now())
+	if playerKey(player.Name) == "" {
+		return fmt.Errorf("save player: empty name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := clonePlayer(player)
+	if err := r.append(journalRecord{Op: "put", Name: snapshot.Name, Player: snapshot}); err != nil {
+		return err
+	}
+	r.put(snapshot)
+	return r.maybeCompact()
+}
+
+func (r *journalPlayerRepository) Get(name string) (*Player, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.players[playerKey(name)]
+	if !ok {
+		return nil, false
+	}
+	return clonePlayer(p), true
+}
+
+func (r *journalPlayerRepository) List() []*Player {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*Player, 0, len(r.order))
+	for _, key := range r.order {
+		list = append(list, clonePlayer(r.players[key]))
+	}
+	return list
+}
+
+func (r *journalPlayerRepository) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.players[playerKey(name)]; !ok {
+		return nil
+	}
+	if err := r.append(journalRecord{Op: "delete", Name: name}); err != nil {
+		return err
+	}
+	r.remove(name)
+	return r.maybeCompact()
+}
+
+func (r *journalPlayerRepository) maybeCompact() error {
+	if r.entries < journalCompactEvery {
+		return nil
+	}
+	// the change is already durable in the journal, a failed compaction only
+	// means the journal keeps growing until the next attempt
+	if err := r.compact(); err != nil {
+		slog.Error("journal compaction failed", "err", err)
+	}
+	return nil
+}
+
+// Compact writes the current roster to snapshot.json and empties the journal.
+func (r *journalPlayerRepository) Compact() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.compact()
+}
+
+func (r *journalPlayerRepository) compact() error {
+	if err := r.writeSnapshot(); err != nil {
+		return err
+	}
+	// a crash between the snapshot and the truncate just replays records the
+	// snapshot already contains
+	if r.journal != nil {
+		if err := r.journal.Truncate(0); err != nil {
+			return fmt.Errorf("truncate journal: %w", err)
+		}
+		if _, err := r.journal.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek journal: %w", err)
+		}
+		if err := r.journal.Sync(); err != nil {
+			return fmt.Errorf("sync journal: %w", err)
+		}
+	}
+	r.entries = 0
+	return nil
+}
+
+func (r *journalPlayerRepository) writeSnapshot() error {
+	list := make([]*Player, 0, len(r.order))
+	for _, key := range r.order {
+		list = append(list, r.players[key])
+	}
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return writeFileAtomic(r.snapshotPath(), append(data, '\n'))
+}
+
+// Close releases the journal file.
+func (r *journalPlayerRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.journal == nil {
+		return nil
+	}
+	err := r.journal.Close()
+	r.journal = nil
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openJournal(t *testing.T, dir, seedPath string) *journalPlayerRepository {
+	t.Helper()
+	r := newJournalPlayerRepository(dir, seedPath)
+	if err := r.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func rosterNames(r PlayerRepository) []string {
+	var names []string
+	for _, p := range r.List() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestJournalReplayStopsAtBadRecord(t *testing.T) {
+	// a record whose checksum does not match, and one cut off mid-write
+	corrupt, err := encodeJournalLine(journalRecord{Op: "put", Name: "Gary", Player: &Player{Name: "Gary"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt[0] ^= 1
+	torn, err := encodeJournalLine(journalRecord{Op: "put", Name: "Oak", Player: &Player{Name: "Oak"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn = torn[:len(torn)/2]
+
+	tests := []struct {
+		name string
+		tail []byte
+	}{
+		{"torn", torn},
+		{"corrupt", corrupt},
+		{"corrupt then torn", append(append([]byte(nil), corrupt...), torn...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := openJournal(t, dir, "")
+			for _, name := range []string{"Ash", "Misty"} {
+				if err := r.Save(&Player{Name: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Delete("Misty"); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Save(&Player{Name: "Brock", Rating: 1200}); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+			goodSize := fileSize(t, r.logPath())
+
+			f, err := os.OpenFile(r.logPath(), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			r = openJournal(t, dir, "")
+			if got, want := rosterNames(r), []string{"Ash", "Brock"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("roster = %v, want %v", got, want)
+			}
+			if p, _ := r.Get("brock"); p == nil || p.Rating != 1200 {
+				t.Errorf("Brock = %+v, want the saved rating 1200", p)
+			}
+			if got := fileSize(t, r.logPath()); got != goodSize {
+				t.Errorf("journal size = %d, want it truncated to %d", got, goodSize)
+			}
+
+			// new records follow the last intact one
+			if err := r.Save(&Player{Name: "Misty"}); err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+			r = openJournal(t, dir, "")
+			if got, want := rosterNames(r), []string{"Ash", "Brock", "Misty"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("roster after another save = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestJournalCompactThenReload(t *testing.T) {
+	dir := t.TempDir()
+	r := openJournal(t, dir, "")
+	for _, name := range []string{"Ash", "Misty", "Brock"} {
+		if err := r.Save(&Player{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Delete("Misty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileSize(t, r.logPath()); got != 0 {
+		t.Errorf("journal size after Compact = %d, want 0", got)
+	}
+	if err := r.Save(&Player{Name: "Ash", Wins: 3}); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	r = openJournal(t, dir, "")
+	if got, want := rosterNames(r), []string{"Ash", "Brock"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("roster = %v, want %v", got, want)
+	}
+	if p, _ := r.Get("Ash"); p == nil || p.Wins != 3 {
+		t.Errorf("Ash = %+v, want the save after the compaction", p)
+	}
+}
+
+func TestJournalImportsSeedOnlyWhenEmpty(t *testing.T) {
+	seedDir := t.TempDir()
+	seedPath := filepath.Join(seedDir, "players.json")
+	seed := newJSONPlayerRepository(seedPath)
+	if err := seed.Save(&Player{Name: "Ash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	r := openJournal(t, dir, seedPath)
+	if got, want := rosterNames(r), []string{"Ash"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("imported roster = %v, want %v", got, want)
+	}
+	if err := r.Delete("Ash"); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// the seed gains a player, but the journal already has data
+	if err := seed.Save(&Player{Name: "Misty"}); err != nil {
+		t.Fatal(err)
+	}
+	r = openJournal(t, dir, seedPath)
+	if got := rosterNames(r); len(got) != 0 {
+		t.Errorf("roster = %v, want the seed ignored once the journal has data", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const maxLevel = 100
+
+// Evolution turns the species with Pokedex index From into To once an
+// instance reaches Level.
+type Evolution struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Level int    `json:"level"`
+}
+
+var evolutions = map[string]Evolution{}
+
+func loadEvolutions(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	list := []Evolution{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	evolutions = make(map[string]Evolution, len(list))
+	for _, e := range list {
+		if speciesByIndex(e.From) == nil || speciesByIndex(e.To) == nil {
+			return fmt.Errorf("evolution %s -> %s: unknown Pokedex index", e.From, e.To)
+		}
+		evolutions[e.From] = e
+	}
+	return nil
+}
+
+// expForLevel is the total experience needed to reach a level (the
+// "medium fast" curve, level^3).
+func expForLevel(level int) int {
+	if level <= 1 {
+		return 0
+	}
+	return level * level * level
+}
+
+func levelForExp(exp int) int {
+	level := 1
+	for level < maxLevel && expForLevel(level+1) <= exp {
+		level++
+	}
+	return level
+}
+
+// statForLevel grows a base stat linearly with the level; the EV multiplier
+// decides how fast. At level 1 the stat is the base stat.
+func statForLevel(base, level int, ev float64) int {
+	return base + int(float64(base)*ev*float64(level-1)/50)
+}
+
+// recalculateStats derives the stats from the species, level and EV
+// multiplier. Damage already taken is kept.
+func (p *OwnedPokemon) recalculateStats() {
+	s := p.Species()
+	if s == nil {
+		return
+	}
+	damage := p.MaxHP - p.HP
+	p.MaxHP = statForLevel(s.HP, p.Level, p.EVPoints)
+	p.Attack = statForLevel(s.Attack, p.Level, p.EVPoints)
+	p.Defense = statForLevel(s.Defense, p.Level, p.EVPoints)
+	p.SpAttack = statForLevel(s.SpAttack, p.Level, p.EVPoints)
+	p.SpDefense = statForLevel(s.SpDefense, p.Level, p.EVPoints)
+	p.Speed = statForLevel(s.Speed, p.Level, p.EVPoints)
+	p.HP = max(p.MaxHP-damage, 0)
+}
+
+// gainExp adds experience, levels the Pokemon up and evolves it when it
+// reaches its evolution level. It returns one line per level-up or evolution.
+func (p *OwnedPokemon) gainExp(exp int) []string {
+	var events []string
+	p.AccumExp += exp
+	for p.Level < levelForExp(p.AccumExp) {
+		p.Level++
+		p.recalculateStats()
+		events = append(events, fmt.Sprintf("⭐ %s grew to level %d!\n", p.Name, p.Level))
+		if e, ok := evolutions[p.Index]; ok && p.Level >= e.Level {
+			events = append(events, p.evolve(e))
+		}
+	}
+	return events
+}
+
+// evolve swaps in the evolved species; level, experience and EVs stay with
+// the instance.
+func (p *OwnedPokemon) evolve(e Evolution) string {
+	next := speciesByIndex(e.To)
+	oldName := p.Name
+	p.Index = next.Index
+	p.Name = next.Name
+	p.species = next
+	p.recalculateStats()
+	return fmt.Sprintf("✨ %s evolved into %s!\n", oldName, p.Name)
+}
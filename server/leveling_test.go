@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// useCharmanderLine swaps in a Pokedex of the Charmander line and its
+// evolutions for the length of the test.
+func useCharmanderLine(t *testing.T) {
+	t.Helper()
+	oldPokedex, oldEvolutions := pokedex, evolutions
+	t.Cleanup(func() {
+		pokedex, evolutions = oldPokedex, oldEvolutions
+		indexPokedex()
+	})
+	pokedex = []Pokemon{
+		{Index: "#004", Name: "Charmander", HP: 39, Attack: 52, Defense: 43, SpAttack: 60, SpDefense: 50, Speed: 65},
+		{Index: "#005", Name: "Charmeleon", HP: 58, Attack: 64, Defense: 58, SpAttack: 80, SpDefense: 65, Speed: 80},
+		{Index: "#006", Name: "Charizard", HP: 78, Attack: 84, Defense: 78, SpAttack: 109, SpDefense: 85, Speed: 100},
+	}
+	indexPokedex()
+	evolutions = map[string]Evolution{
+		"#004": {From: "#004", To: "#005", Level: 16},
+		"#005": {From: "#005", To: "#006", Level: 36},
+	}
+}
+
+func TestLevelForExp(t *testing.T) {
+	for _, level := range []int{2, 5, 16, 36, maxLevel} {
+		if got := levelForExp(expForLevel(level)); got != level {
+			t.Errorf("levelForExp(expForLevel(%d)) = %d", level, got)
+		}
+		if got := levelForExp(expForLevel(level) - 1); got != level-1 {
+			t.Errorf("levelForExp(expForLevel(%d)-1) = %d, want %d", level, got, level-1)
+		}
+	}
+	if got := levelForExp(expForLevel(maxLevel) * 2); got != maxLevel {
+		t.Errorf("levelForExp past the cap = %d, want %d", got, maxLevel)
+	}
+}
+
+func TestGainExp(t *testing.T) {
+	useCharmanderLine(t)
+	tests := []struct {
+		name       string
+		from       int // starting level
+		to         int // level whose total experience is reached
+		extra      int // experience on top of that
+		wantName   string
+		wantEvents int
+	}{
+		{"not enough for a level", 5, 5, 50, "Charmander", 0},
+		{"one level", 5, 6, 0, "Charmander", 1},
+		{"several levels", 1, 10, 0, "Charmander", 9},
+		{"evolves at 16", 15, 16, 0, "Charmeleon", 2},
+		{"evolves twice", 1, 40, 0, "Charizard", 41},
+		{"stops at the last level", 99, maxLevel, 1 << 30, "Charizard", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &OwnedPokemon{Index: "#004", Name: "Charmander", Level: tt.from, AccumExp: expForLevel(tt.from), EVPoints: 1}
+			if tt.from >= 36 {
+				p.Index, p.Name = "#006", "Charizard"
+			}
+			p.recalculateStats()
+			p.HP = p.MaxHP - 5
+
+			events := p.gainExp(expForLevel(tt.to) - p.AccumExp + tt.extra)
+			if p.Level != tt.to || p.Name != tt.wantName || len(events) != tt.wantEvents {
+				t.Errorf("level %d %s with %d events, want level %d %s with %d events: %q",
+					p.Level, p.Name, len(events), tt.to, tt.wantName, tt.wantEvents, events)
+			}
+			s := p.Species()
+			if s.Name != p.Name || p.Index != s.Index {
+				t.Errorf("species = %s, want %s", s.Name, p.Name)
+			}
+			if want := statForLevel(s.HP, p.Level, p.EVPoints); p.MaxHP != want || p.HP != want-5 {
+				t.Errorf("HP = %d/%d, want %d/%d with the damage kept", p.HP, p.MaxHP, want-5, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// auditOff turns the audit log off.
+const auditOff = "off"
+
+// audit records gameplay events, one JSON object per line with the player
+// names as attributes, so a player's history can be grepped or replayed.
+// Events: login, login_failed, mode, leave, capture, encounter_start,
+// encounter_end, battle_start, battle_turn, battle_end, exp and admin.
+var audit = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+// setupLogging installs the server log on stderr and opens the audit log.
+func setupLogging(c LogConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if c.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	// what still goes through the log package, like net/http errors
+	log.SetFlags(0)
+	log.SetOutput(slogWriter{})
+
+	if c.Audit == auditOff {
+		return nil
+	}
+	file, err := os.OpenFile(c.Audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	audit = slog.New(slog.NewJSONHandler(file, nil))
+	return nil
+}
+
+// slogWriter passes lines of the standard logger on as warnings.
+type slogWriter struct{}
+
+func (slogWriter) Write(p []byte) (int, error) {
+	slog.Warn(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// fatal logs an error that stops the server from starting and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
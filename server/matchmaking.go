@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Matchmaker pairs waiting battle-mode participants. In arrival order by
+// default; with byRating the longest waiting player gets the closest rated
+// opponent inside a window that widens the longer they wait.
+type Matchmaker struct {
+	mu       sync.Mutex
+	queue    []queuedParticipant
+	wake     chan struct{}
+	byRating bool
+}
+
+type queuedParticipant struct {
+	participant *Participant
+	since       time.Time
+}
+
+func newMatchmaker() *Matchmaker {
+	return &Matchmaker{wake: make(chan struct{}, 1)}
+}
+
+// Enqueue puts a participant at the back of the queue.
+func (m *Matchmaker) Enqueue(p *Participant) {
+	m.mu.Lock()
+	m.queue = append(m.queue, queuedParticipant{participant: p, since: time.Now()})
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Remove takes a participant out of the queue and reports whether it was
+// still waiting.
+func (m *Matchmaker) Remove(p *Participant) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, queued := range m.queue {
+		if queued.participant == p {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of waiting participants.
+func (m *Matchmaker) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.queue)
+}
+
+// pair pops the next two participants, or returns nil if fewer than two wait.
+func (m *Matchmaker) pair() (*Participant, *Participant) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.queue) < 2 {
+		return nil, nil
+	}
+	if !m.byRating {
+		p1, p2 := m.queue[0].participant, m.queue[1].participant
+		m.queue = m.queue[2:]
+		return p1, p2
+	}
+	now := time.Now()
+	for i, waiting := range m.queue {
+		rating := currentRating(waiting.participant.player)
+		window := baseRatingWindow + int(now.Sub(waiting.since).Seconds())*ratingWindowGrowth
+		best, bestDiff := -1, 0
+		for j := i + 1; j < len(m.queue); j++ {
+			diff := rating - currentRating(m.queue[j].participant.player)
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff <= window && (best < 0 || diff < bestDiff) {
+				best, bestDiff = j, diff
+			}
+		}
+		if best >= 0 {
+			p1, p2 := waiting.participant, m.queue[best].participant
+			m.queue = append(m.queue[:best], m.queue[best+1:]...)
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return p1, p2
+		}
+	}
+	return nil, nil
+}
+
+// run starts a battle session for every pair that forms until the server
+// stops. The ticker lets rating windows widen while nobody new joins.
+func (m *Matchmaker) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.wake:
+		case <-ticker.C:
+		case <-stopping:
+			return
+		}
+		for {
+			p1, p2 := m.pair()
+			if p1 == nil {
+				break
+			}
+			p1.matched <- struct{}{}
+			p2.matched <- struct{}{}
+			startBattle(p1, p2)
+		}
+	}
+}
+
+// waitForMatch keeps reading the client while it is queued so a disconnect
+// takes it out of the queue instead of stalling the next battle.
+func waitForMatch(p *Participant) {
+	p.send(notice("\n⌛ Waiting for an opponent...\n"))
+	for {
+		select {
+		case <-p.matched:
+			return
+		case line, ok := <-p.input:
+			if !ok {
+				if matchmaker.Remove(p) {
+					closeCh <- p
+				}
+				return
+			}
+			if handleCommand(p, line) {
+				continue
+			}
+			p.send(notice(fmt.Sprintf("Still waiting for an opponent (%d in queue).\n", matchmaker.Len())))
+		}
+	}
+}
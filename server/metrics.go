@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The server exports its metrics in the Prometheus text format on the
+// HTTP server's /metrics. Counters and histograms are updated where things
+// happen; gauges are read from the game state on every scrape.
+
+var (
+	spawnWaves       atomic.Uint64
+	pokemonSpawned   atomic.Uint64
+	pokemonDespawned = newCounterVec("reason", "expired", "admin", "fled", "fainted")
+	captures         atomic.Uint64
+	playerMoves      = newCounterVec("result", "moved", "blocked", "wall", "encounter", "team_full")
+	encounters       = newCounterVec("outcome", "caught", "fled", "fainted", "ran", "lost", "left")
+	battlesEnded     = newCounterVec("result", "win", "draw")
+	battleDuration   = newHistogram(10, 30, 60, 120, 300, 600, 1200, 1800)
+	playerSaves      = newCounterVec("result", "ok", "error")
+	saveDuration     = newHistogram(.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1)
+)
+
+// counterVec is a counter split by the values of one label.
+type counterVec struct {
+	label  string
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// newCounterVec starts the given label values at zero so they are exported
+// before the first increment.
+func newCounterVec(label string, values ...string) *counterVec {
+	c := &counterVec{label: label, values: map[string]uint64{}}
+	for _, v := range values {
+		c.values[v] = 0
+	}
+	return c
+}
+
+func (c *counterVec) add(value string, n uint64) {
+	c.mu.Lock()
+	c.values[value] += n
+	c.mu.Unlock()
+}
+
+func (c *counterVec) inc(value string) { c.add(value, 1) }
+
+// histogram counts observations into cumulative buckets, in seconds.
+type histogram struct {
+	bounds []float64
+	mu     sync.Mutex
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// since observes the time elapsed since start.
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+// observeSave records a profile save that began at start.
+func observeSave(start time.Time, err error) {
+	saveDuration.since(start)
+	if err != nil {
+		playerSaves.inc("error")
+	} else {
+		playerSaves.inc("ok")
+	}
+}
+
+// metricsWriter writes metric families in the text exposition format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (m metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m metricsWriter) sample(name, labels string, value float64) {
+	fmt.Fprintf(m.w, "%s%s %v\n", name, labels, value)
+}
+
+func (m metricsWriter) counter(name, help string, value uint64) {
+	m.family(name, "counter", help)
+	m.sample(name, "", float64(value))
+}
+
+func (m metricsWriter) gauge(name, help string, value int) {
+	m.family(name, "gauge", help)
+	m.sample(name, "", float64(value))
+}
+
+func (m metricsWriter) counterVec(name, help string, c *counterVec) {
+	c.mu.Lock()
+	values := make(map[string]uint64, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	c.mu.Unlock()
+	m.labeled(name, "counter", help, c.label, values)
+}
+
+// labeled writes one sample per label value, sorted by value.
+func (m metricsWriter) labeled(name, typ, help, label string, values map[string]uint64) {
+	m.family(name, typ, help)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.sample(name, labelSet(label, k), float64(values[k]))
+	}
+}
+
+func (m metricsWriter) histogram(name, help string, h *histogram) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum := h.sum
+	h.mu.Unlock()
+	m.family(name, "histogram", help)
+	var total uint64
+	for i, n := range counts {
+		total += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = fmt.Sprint(h.bounds[i])
+		}
+		m.sample(name+"_bucket", labelSet("le", le), float64(total))
+	}
+	m.sample(name+"_sum", "", sum)
+	m.sample(name+"_count", "", float64(total))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelSet(name, value string) string {
+	return "{" + name + `="` + labelEscaper.Replace(value) + `"}`
+}
+
+// sessionStats reads the gauges that come from the connected sessions:
+// how many there are in each mode and how many frames each one has queued.
+func sessionStats() (modes map[string]uint64, depths map[string]uint64) {
+	mu.Lock()
+	connected := append([]*Session(nil), sessions...)
+	playing := append([]*Participant(nil), participants...)
+	mu.Unlock()
+	modeOf := map[*Session]string{}
+	for _, p := range playing {
+		mode := "battle"
+		if p.catchMode {
+			mode = "catch"
+		}
+		modeOf[p.currentSession()] = mode
+	}
+	modes = map[string]uint64{"lobby": 0, "catch": 0, "battle": 0}
+	depths = map[string]uint64{}
+	for _, s := range connected {
+		mode, ok := modeOf[s]
+		if !ok {
+			mode = "lobby"
+		}
+		modes[mode]++
+		s.mu.Lock()
+		name := s.account
+		s.mu.Unlock()
+		if name == "" {
+			name = s.netConn.RemoteAddr().String()
+		}
+		depths[name] = uint64(s.QueueLen())
+	}
+	return modes, depths
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	modes, depths := sessionStats()
+	battleMu.Lock()
+	activeBattles := len(battles)
+	battleMu.Unlock()
+	world.mux.Lock()
+	wild := len(world.pokemons)
+	world.mux.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := metricsWriter{bufio.NewWriter(w)}
+	m.labeled("pokegame_sessions", "gauge", "Connected sessions by mode; lobby is not playing yet.", "mode", modes)
+	m.labeled("pokegame_session_queue_depth", "gauge", "Frames waiting to be written to each session.", "session", depths)
+	m.gauge("pokegame_battles_active", "Battles being played.", activeBattles)
+	m.gauge("pokegame_matchmaking_queue_length", "Battle-mode players waiting for an opponent.", matchmaker.Len())
+	m.gauge("pokegame_wild_pokemon", "Wild Pokemon in the world.", wild)
+	m.counter("pokegame_spawn_waves_total", "Spawn waves.", spawnWaves.Load())
+	m.counter("pokegame_pokemon_spawned_total", "Wild Pokemon spawned, by waves or admins.", pokemonSpawned.Load())
+	m.counterVec("pokegame_pokemon_despawned_total", "Wild Pokemon removed without being caught.", pokemonDespawned)
+	m.counter("pokegame_captures_total", "Wild Pokemon caught; rate(...[1m]) * 60 gives captures per minute.", captures.Load())
+	m.counterVec("pokegame_player_moves_total", "Moves in the world by outcome.", playerMoves)
+	m.counterVec("pokegame_encounters_total", "Finished wild battles by outcome.", encounters)
+	m.counterVec("pokegame_battles_total", "Finished battles.", battlesEnded)
+	m.histogram("pokegame_battle_duration_seconds", "How long finished battles took.", battleDuration)
+	m.counterVec("pokegame_player_saves_total", "Player profile saves by outcome.", playerSaves)
+	m.histogram("pokegame_player_save_duration_seconds", "Time to write a player profile to the store.", saveDuration)
+	m.w.Flush()
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+const (
+	maxMoves       = 4
+	criticalChance = 16 // one hit in criticalChance is critical
+	criticalBonus  = 1.5
+)
+
+// Move is one attack from moves.json. Accuracy is a percentage, 0 means the
+// move never misses. Status moves deal no damage and change Stat of the
+// Target ("self" or "foe") by Stages instead.
+type Move struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Power    int    `json:"power"`
+	Accuracy int    `json:"accuracy"`
+	PP       int    `json:"pp"`
+	Priority int    `json:"priority"`
+	Stat     string `json:"stat,omitempty"`
+	Stages   int    `json:"stages,omitempty"`
+	Target   string `json:"target,omitempty"`
+}
+
+// MoveSlot is a move known by an instance together with its remaining PP.
+type MoveSlot struct {
+	Name  string `json:"name"`
+	PP    int    `json:"pp"`
+	MaxPP int    `json:"max_pp"`
+}
+
+type moveData struct {
+	Moves     []Move              `json:"moves"`
+	Learnsets map[string][]string `json:"learnsets"`
+}
+
+var (
+	moves     = map[string]*Move{}
+	moveOrder []*Move
+	learnsets = map[string][]string{}
+	// struggleMove is used once every move is out of PP
+	struggleMove = &Move{Name: "Struggle", Type: "none", Category: "physical", Power: 50}
+)
+
+func loadMoves(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	var data moveData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	byName := make(map[string]*Move, len(data.Moves))
+	order := make([]*Move, 0, len(data.Moves))
+	for i := range data.Moves {
+		m := &data.Moves[i]
+		m.Type = strings.ToLower(m.Type)
+		switch m.Category {
+		case "physical", "special", "status":
+		default:
+			return fmt.Errorf("%s: move %s has unknown category %q", path, m.Name, m.Category)
+		}
+		if m.PP <= 0 {
+			return fmt.Errorf("%s: move %s needs a positive pp", path, m.Name)
+		}
+		byName[strings.ToLower(m.Name)] = m
+		order = append(order, m)
+	}
+	for index, names := range data.Learnsets {
+		if len(names) > maxMoves {
+			return fmt.Errorf("%s: learnset %s has more than %d moves", path, index, maxMoves)
+		}
+		for _, name := range names {
+			if byName[strings.ToLower(name)] == nil {
+				return fmt.Errorf("%s: learnset %s uses unknown move %s", path, index, name)
+			}
+		}
+	}
+	moves = byName
+	moveOrder = order
+	learnsets = data.Learnsets
+	return nil
+}
+
+func findMove(name string) *Move {
+	return moves[strings.ToLower(name)]
+}
+
+// defaultMoveset uses the species learnset if moves.json has one, otherwise
+// up to two damaging moves per type of the species topped up with normal moves.
+func defaultMoveset(species *Pokemon) []*MoveSlot {
+	var names []string
+	if learnset, ok := learnsets[species.Index]; ok {
+		names = learnset
+	} else {
+		for _, t := range append(append([]string{}, species.Type...), "normal") {
+			perType := 0
+			for _, m := range moveOrder {
+				if len(names) == maxMoves || perType == 2 {
+					break
+				}
+				if m.Category != "status" && strings.EqualFold(m.Type, t) && !containsName(names, m.Name) {
+					names = append(names, m.Name)
+					perType++
+				}
+			}
+		}
+	}
+	slots := make([]*MoveSlot, 0, len(names))
+	for _, name := range names {
+		if m := findMove(name); m != nil {
+			slots = append(slots, &MoveSlot{Name: m.Name, PP: m.PP, MaxPP: m.PP})
+		}
+	}
+	return slots
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// usableMoves returns the slots that still have PP.
+func (p *OwnedPokemon) usableMoves() []*MoveSlot {
+	var usable []*MoveSlot
+	for _, slot := range p.Moves {
+		if slot.PP > 0 && findMove(slot.Name) != nil {
+			usable = append(usable, slot)
+		}
+	}
+	return usable
+}
+
+func (p *OwnedPokemon) restorePP() {
+	for _, slot := range p.Moves {
+		slot.PP = slot.MaxPP
+	}
+}
+
+// stageMultiplier turns a stat stage between -6 and +6 into a multiplier.
+func stageMultiplier(stage int) float64 {
+	if stage >= 0 {
+		return float64(2+stage) / 2
+	}
+	return 2 / float64(2-stage)
+}
+
+// attackResult describes one use of a move.
+type attackResult struct {
+	damage        int
+	effectiveness float64
+	critical      bool
+	missed        bool
+}
+
+func (p *Participant) stageMultiplier(stat string) float64 {
+	return stageMultiplier(p.stages[stat])
+}
+
+// sendOut makes pokemon the active one; stat stages do not carry over.
+func (p *Participant) sendOut(pokemon *OwnedPokemon) {
+	p.curPokemon = pokemon
+	p.stages = map[string]int{}
+}
+
+// chooseMove picks a random move that still has PP, nil means Struggle.
+func chooseMove(p *OwnedPokemon) *MoveSlot {
+	usable := p.usableMoves()
+	if len(usable) == 0 {
+		return nil
+	}
+	return usable[rand.Intn(len(usable))]
+}
+
+func slotMove(slot *MoveSlot) *Move {
+	if slot == nil {
+		return struggleMove
+	}
+	if m := findMove(slot.Name); m != nil {
+		return m
+	}
+	return struggleMove
+}
+
+// turnOrder puts the higher priority move first, then the faster Pokemon;
+// speed ties are broken at random.
+func turnOrder(p1, p2 *Participant, move1, move2 *Move) (first, second *Participant) {
+	if move1.Priority != move2.Priority {
+		if move1.Priority > move2.Priority {
+			return p1, p2
+		}
+		return p2, p1
+	}
+	speed1 := float64(p1.curPokemon.Speed) * p1.stageMultiplier("speed")
+	speed2 := float64(p2.curPokemon.Speed) * p2.stageMultiplier("speed")
+	if speed1 > speed2 || (speed1 == speed2 && rand.Intn(2) == 0) {
+		return p1, p2
+	}
+	return p2, p1
+}
+
+// applyStatus applies the stat change of a status move and returns the log line.
+func applyStatus(attacker, defender *Participant, move *Move) string {
+	target := defender
+	if move.Target == "self" {
+		target = attacker
+	}
+	if move.Stat == "" || move.Stages == 0 {
+		return "But nothing happened.\n"
+	}
+	stage := target.stages[move.Stat]
+	next := min(max(stage+move.Stages, -6), 6)
+	target.stages[move.Stat] = next
+	statName := strings.ReplaceAll(move.Stat, "_", " ")
+	switch {
+	case next == stage && move.Stages > 0:
+		return fmt.Sprintf("%s's %s won't go any higher!\n", target.curPokemon.Name, statName)
+	case next == stage:
+		return fmt.Sprintf("%s's %s won't go any lower!\n", target.curPokemon.Name, statName)
+	case move.Stages > 0:
+		return fmt.Sprintf("%s's %s rose!\n", target.curPokemon.Name, statName)
+	}
+	return fmt.Sprintf("%s's %s fell!\n", target.curPokemon.Name, statName)
+}
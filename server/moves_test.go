@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestTurnOrder(t *testing.T) {
+	fighter := func(speed, stage int) *Participant {
+		return &Participant{curPokemon: &OwnedPokemon{Speed: speed}, stages: map[string]int{"speed": stage}}
+	}
+	tests := []struct {
+		name                 string
+		speed1, stage1       int
+		speed2, stage2       int
+		priority1, priority2 int
+		p1First              bool
+	}{
+		{"faster goes first", 90, 0, 50, 0, 0, 0, true},
+		{"slower goes second", 50, 0, 90, 0, 0, 0, false},
+		{"priority beats speed", 50, 0, 90, 0, 1, 0, true},
+		{"priority of the second", 90, 0, 50, 0, 0, 1, false},
+		{"negative priority", 90, 0, 50, 0, -1, 0, false},
+		{"speed stages count", 100, -2, 60, 0, 0, 0, false},
+		{"raised speed", 40, 2, 70, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p1, p2 := fighter(tt.speed1, tt.stage1), fighter(tt.speed2, tt.stage2)
+			first, second := turnOrder(p1, p2, &Move{Priority: tt.priority1}, &Move{Priority: tt.priority2})
+			if (first == p1) != tt.p1First || second == first {
+				t.Errorf("p1 first = %v, want %v", first == p1, tt.p1First)
+			}
+		})
+	}
+}
+
+func TestTurnOrderSpeedTie(t *testing.T) {
+	p1 := &Participant{curPokemon: &OwnedPokemon{Speed: 70}, stages: map[string]int{}}
+	p2 := &Participant{curPokemon: &OwnedPokemon{Speed: 70}, stages: map[string]int{}}
+	firsts := map[*Participant]int{}
+	for i := 0; i < 200; i++ {
+		first, _ := turnOrder(p1, p2, &Move{}, &Move{})
+		firsts[first]++
+	}
+	if firsts[p1] == 0 || firsts[p2] == 0 {
+		t.Errorf("a speed tie went the same way 200 times: %d to %d", firsts[p1], firsts[p2])
+	}
+}
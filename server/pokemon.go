@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mrand "math/rand"
+	"time"
+)
+
+// OwnedPokemon is one individual Pokemon, wild or caught. The Pokedex entry
+// it belongs to is referenced by Index and never modified.
+type OwnedPokemon struct {
+	ID         string      `json:"id"`
+	Index      string      `json:"index"`
+	Name       string      `json:"name"`
+	Level      int         `json:"level"`
+	AccumExp   int         `json:"accum_exp"`
+	HP         int         `json:"hp"`
+	MaxHP      int         `json:"max_hp"`
+	Attack     int         `json:"attack"`
+	Defense    int         `json:"defense"`
+	SpAttack   int         `json:"sp_attack"`
+	SpDefense  int         `json:"sp_defense"`
+	Speed      int         `json:"speed"`
+	EVPoints   float64     `json:"EVPoints"`
+	Moves      []*MoveSlot `json:"moves"`
+	Deployable bool        `json:"deployable"`
+	CaughtAt   time.Time   `json:"caught_at"`
+	species    *Pokemon
+	pos        Position
+	spawnTime  time.Time
+	avatar     string
+	engaged    bool // a wild Pokemon in an encounter, guarded by world.mux
+}
+
+var pokedexIndex = map[string]*Pokemon{}
+
+// indexPokedex must run once the Pokedex is loaded and before any instance
+// looks up its species.
+func indexPokedex() {
+	pokedexIndex = make(map[string]*Pokemon, len(pokedex))
+	for i := range pokedex {
+		pokedexIndex[pokedex[i].Index] = &pokedex[i]
+	}
+}
+
+func speciesByIndex(index string) *Pokemon {
+	return pokedexIndex[index]
+}
+
+func newPokemonID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newPokemonInstance creates a fresh individual of the given species with a
+// random EV multiplier between 0.5 and 1.
+func newPokemonInstance(species *Pokemon, level int) *OwnedPokemon {
+	p := &OwnedPokemon{
+		ID:         newPokemonID(),
+		Index:      species.Index,
+		Name:       species.Name,
+		Level:      level,
+		HP:         species.HP,
+		MaxHP:      species.HP,
+		Attack:     species.Attack,
+		Defense:    species.Defense,
+		SpAttack:   species.SpAttack,
+		SpDefense:  species.SpDefense,
+		Speed:      species.Speed,
+		EVPoints:   math.Round((0.5+mrand.Float64()/2)*100) / 100,
+		Moves:      defaultMoveset(species),
+		Deployable: true,
+		species:    species,
+	}
+	if level > 1 {
+		p.AccumExp = expForLevel(level)
+		p.recalculateStats()
+	}
+	return p
+}
+
+// Species returns the Pokedex entry of the instance, or nil if the index is
+// not in the Pokedex.
+func (p *OwnedPokemon) Species() *Pokemon {
+	if p.species == nil {
+		p.species = speciesByIndex(p.Index)
+	}
+	return p.species
+}
+
+// Types returns the types of the species.
+func (p *OwnedPokemon) Types() []string {
+	if s := p.Species(); s != nil {
+		return s.Type
+	}
+	return nil
+}
+
+// bind fills in what older saves did not store: an ID, a level, max HP and
+// a moveset.
+func (p *OwnedPokemon) bind() {
+	if p.ID == "" {
+		p.ID = newPokemonID()
+	}
+	if p.Level < 1 {
+		p.Level = 1
+	}
+	if p.MaxHP <= 0 {
+		if s := p.Species(); s != nil {
+			p.MaxHP = s.HP
+		} else {
+			p.MaxHP = p.HP
+		}
+	}
+	if len(p.Moves) == 0 {
+		if s := p.Species(); s != nil {
+			p.Moves = defaultMoveset(s)
+		}
+	}
+}
+
+func (p *OwnedPokemon) heal() {
+	p.HP = p.MaxHP
+	p.Deployable = true
+	p.restorePP()
+}
+
+func bindRoster(player *Player) {
+	for _, p := range player.PokemonList {
+		p.bind()
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	initialRating      = 1000
+	eloK               = 32
+	maxHistory         = 50
+	leaderboardSize    = 10
+	baseRatingWindow   = 100
+	ratingWindowGrowth = 25 // rating points added per second of waiting
+)
+
+// BattleRecord is one finished battle in a player's history.
+type BattleRecord struct {
+	Opponent     string    `json:"opponent"`
+	Result       string    `json:"result"`
+	RatingChange int       `json:"rating_change"`
+	At           time.Time `json:"at"`
+}
+
+// currentRating treats players saved before ratings existed as new players.
+func currentRating(p *Player) int {
+	if p.Rating == 0 {
+		return initialRating
+	}
+	return p.Rating
+}
+
+func expectedScore(rating, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
+
+// eloChange is the rating change for a player scoring score (1 win, 0.5
+// draw, 0 loss) against an opponent.
+func eloChange(rating, opponent int, score float64) int {
+	return int(math.Round(eloK * (score - expectedScore(rating, opponent))))
+}
+
+// recordResult updates ratings, records and histories of both players. For a
+// draw the order of the two players does not matter.
+func recordResult(winner, loser *Player, draw bool) {
+	winnerRating, loserRating := currentRating(winner), currentRating(loser)
+	winnerScore, loserScore := 1.0, 0.0
+	winnerResult, loserResult := "win", "loss"
+	if draw {
+		winnerScore, loserScore = 0.5, 0.5
+		winnerResult, loserResult = "draw", "draw"
+		winner.Draws++
+		loser.Draws++
+	} else {
+		winner.Wins++
+		loser.Losses++
+	}
+	winnerChange := eloChange(winnerRating, loserRating, winnerScore)
+	loserChange := eloChange(loserRating, winnerRating, loserScore)
+	winner.Rating = winnerRating + winnerChange
+	loser.Rating = loserRating + loserChange
+
+	now := time.Now()
+	winner.addHistory(BattleRecord{Opponent: loser.Name, Result: winnerResult, RatingChange: winnerChange, At: now})
+	loser.addHistory(BattleRecord{Opponent: winner.Name, Result: loserResult, RatingChange: loserChange, At: now})
+}
+
+func (p *Player) addHistory(record BattleRecord) {
+	p.History = append(p.History, record)
+	if len(p.History) > maxHistory {
+		p.History = p.History[len(p.History)-maxHistory:]
+	}
+}
+
+// rankedPlayers returns every stored player, best rating first.
+func rankedPlayers() []*Player {
+	list := playerRepo.List()
+	sort.SliceStable(list, func(i, j int) bool {
+		ri, rj := currentRating(list[i]), currentRating(list[j])
+		if ri != rj {
+			return ri > rj
+		}
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+// leaderboard renders the top n players and the rank of the requester.
+func leaderboard(requester string, n int) string {
+	ranked := rankedPlayers()
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n🏆 LEADERBOARD (top %d)\n", n)
+	for i, p := range ranked {
+		if i == n {
+			break
+		}
+		fmt.Fprintf(&b, "%d. %s %d (%dW-%dL-%dD)\n", i+1, p.Name, currentRating(p), p.Wins, p.Losses, p.Draws)
+	}
+	for i, p := range ranked {
+		if playerKey(p.Name) == playerKey(requester) {
+			fmt.Fprintf(&b, "Your rank: #%d of %d with %d points\n", i+1, len(ranked), currentRating(p))
+			return b.String()
+		}
+	}
+	fmt.Fprintf(&b, "You are not ranked yet\n")
+	return b.String()
+}
+
+// handleCommand answers chat-style commands typed by battle-mode players and
+// reports whether line was one.
+func handleCommand(p *Participant, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return false
+	}
+	switch fields[0] {
+	case "/leaderboard":
+		n := leaderboardSize
+		if len(fields) > 1 {
+			if v, err := strconv.Atoi(fields[1]); err == nil && v > 0 {
+				n = v
+			}
+		}
+		p.send(notice(leaderboard(p.player.Name, n)))
+	default:
+		p.send(notice(fmt.Sprintf("Unknown command %s\n", fields[0])))
+	}
+	return true
+}
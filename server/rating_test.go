@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRecordResult(t *testing.T) {
+	tests := []struct {
+		name                  string
+		winner, loser         int
+		draw                  bool
+		wantWinner, wantLoser int
+	}{
+		{"equal ratings", 1000, 1000, false, 1016, 984},
+		{"favourite wins", 1200, 1000, false, 1208, 992},
+		{"upset", 1000, 1200, false, 1024, 1176},
+		{"draw between equals", 1000, 1000, true, 1000, 1000},
+		{"draw against a weaker player", 1200, 1000, true, 1192, 1008},
+		{"unrated players start at 1000", 0, 0, false, 1016, 984},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winner := &Player{Name: "Ash", Rating: tt.winner}
+			loser := &Player{Name: "Gary", Rating: tt.loser}
+			before := currentRating(winner) + currentRating(loser)
+			recordResult(winner, loser, tt.draw)
+
+			if winner.Rating != tt.wantWinner || loser.Rating != tt.wantLoser {
+				t.Errorf("ratings = %d, %d, want %d, %d", winner.Rating, loser.Rating, tt.wantWinner, tt.wantLoser)
+			}
+			if after := winner.Rating + loser.Rating; after != before {
+				t.Errorf("rating sum went from %d to %d, want the change zero-sum", before, after)
+			}
+			w, l := winner.History[0], loser.History[0]
+			if w.RatingChange != -l.RatingChange || w.Opponent != "Gary" || l.Opponent != "Ash" {
+				t.Errorf("history = %+v and %+v, want mirrored records", w, l)
+			}
+			wantW, wantL := "win", "loss"
+			if tt.draw {
+				wantW, wantL = "draw", "draw"
+			}
+			if w.Result != wantW || l.Result != wantL {
+				t.Errorf("results = %s, %s, want %s, %s", w.Result, l.Result, wantW, wantL)
+			}
+		})
+	}
+}
+
+func TestHistoryIsCapped(t *testing.T) {
+	winner := &Player{Name: "Ash"}
+	for i := 0; i < maxHistory+10; i++ {
+		recordResult(winner, &Player{Name: strconv.Itoa(i)}, false)
+	}
+	if len(winner.History) != maxHistory {
+		t.Fatalf("history length = %d, want %d", len(winner.History), maxHistory)
+	}
+	if winner.Wins != maxHistory+10 {
+		t.Errorf("wins = %d, want every battle counted", winner.Wins)
+	}
+	// the oldest records are the ones dropped
+	if first, last := winner.History[0].Opponent, winner.History[maxHistory-1].Opponent; first != "10" || last != strconv.Itoa(maxHistory+9) {
+		t.Errorf("history runs from %s to %s, want 10 to %d", first, last, maxHistory+9)
+	}
+}
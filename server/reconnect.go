@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"pokeGame/protocol"
+)
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// send queues msg on the participant's current session.
+func (p *Participant) send(msg Message) {
+	p.currentSession().Send(msg)
+}
+
+// prompt sends a battle prompt and remembers it so it can be asked again
+// after a reconnect.
+func (p *Participant) prompt(text string) {
+	msg := battlePrompt(text)
+	p.mu.Lock()
+	p.lastPrompt = &msg
+	p.mu.Unlock()
+	p.send(msg)
+}
+
+func (p *Participant) clearPrompt() {
+	p.mu.Lock()
+	p.lastPrompt = nil
+	p.mu.Unlock()
+}
+
+func (p *Participant) currentSession() *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.session
+}
+
+func (p *Participant) isConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+// disconnect is called by the reader of session when it fails. The
+// participant is held for cfg.ReconnectGrace before it is let go.
+func (p *Participant) disconnect(session *Session) {
+	session.teardown()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.session != session || p.gone {
+		return
+	}
+	p.connected = false
+	if p.kicked {
+		p.graceTimer = time.AfterFunc(0, p.expire)
+		return
+	}
+	slog.Info("player disconnected", "player", p.player.Name, "grace", cfg.ReconnectGrace)
+	p.graceTimer = time.AfterFunc(cfg.ReconnectGrace, p.expire)
+}
+
+// kick says goodbye and closes the session for good. The participant
+// cannot resume and is let go as soon as its reader stops.
+func (p *Participant) kick(reason string) {
+	p.mu.Lock()
+	p.kicked = true
+	session := p.session
+	if !p.connected && !p.gone {
+		// already waiting for a reconnect that may not come
+		p.graceTimer.Stop()
+		p.graceTimer = time.AfterFunc(0, p.expire)
+	}
+	p.mu.Unlock()
+	session.Send(bye(reason))
+	session.Close()
+}
+
+// expire lets go of a participant that did not come back in time. Battle
+// participants see their input closed, which forfeits or leaves the queue.
+func (p *Participant) expire() {
+	p.mu.Lock()
+	if p.connected || p.gone {
+		p.mu.Unlock()
+		return
+	}
+	p.gone = true
+	p.mu.Unlock()
+	slog.Info("player did not reconnect", "player", p.player.Name)
+	if p.catchMode {
+		closeCh <- p
+		return
+	}
+	close(p.input)
+}
+
+// leave marks the participant as finished so a late grace timer does
+// nothing.
+func (p *Participant) leave() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gone = true
+	if p.graceTimer != nil {
+		p.graceTimer.Stop()
+	}
+}
+
+// resume attaches session to the participant if token matches. A session
+// the server still believes to be alive is replaced, since the client would
+// not resume unless it had lost it.
+func (p *Participant) resume(session *Session, token string) error {
+	p.mu.Lock()
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+		p.mu.Unlock()
+		return fmt.Errorf("invalid resume token")
+	}
+	if p.gone || p.kicked {
+		p.mu.Unlock()
+		return fmt.Errorf("the session of %s has already ended", p.player.Name)
+	}
+	if p.graceTimer != nil {
+		p.graceTimer.Stop()
+	}
+	old := p.session
+	p.session = session
+	p.connected = true
+	p.mu.Unlock()
+	// the reader of the old session sees the error and finds it replaced
+	old.teardown()
+	return nil
+}
+
+// findParticipant returns the participant playing under name.
+func findParticipant(name string) *Participant {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range participants {
+		if playerKey(p.player.Name) == playerKey(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// resumeParticipant hands the new session to a dropped participant and picks
+// up where it left off.
+func resumeParticipant(session *Session, name, token string) error {
+	p := findParticipant(name)
+	if p == nil {
+		return fmt.Errorf("there is no session of %s to resume", name)
+	}
+	if err := p.resume(session, token); err != nil {
+		return err
+	}
+	slog.Info("player reconnected", "player", p.player.Name)
+	audit.Info("login", "player", p.player.Name, "method", "resume", "remote", session.netConn.RemoteAddr().String())
+	session.setAccount(p.player.Name)
+	session.Send(Message{typ: protocol.TypeLoginOK, payload: protocol.LoginOK{Name: p.player.Name, ResumeToken: p.token, Resumed: true}})
+	if p.catchMode {
+		world.watch(p)
+		go handlePlayerMovement(p, world)
+		return nil
+	}
+	session.Send(notice("🔌 Welcome back, your battle continues.\n"))
+	p.mu.Lock()
+	last := p.lastPrompt
+	p.mu.Unlock()
+	if last != nil {
+		session.Send(*last)
+	}
+	go readInputs(p)
+	return nil
+}
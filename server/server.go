@@ -1,20 +1,26 @@
 package main
 
 import (
-	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/eiannone/keyboard"
+	"pokeGame/config"
+	"pokeGame/protocol"
 )
 
 type Pokemon struct {
@@ -33,218 +39,250 @@ type Pokemon struct {
 	Height      string   `json:"height"`
 	Weight      string   `json:"weight"`
 	ImageURL    string   `json:"image_url"`
-	Level       int      `json:"level"`
-	AccumExp    int      `json:"accum_exp"`
-	Deployable  bool     `json:"deployable"`
-	EVPoints    float64
-	pos         Position
-	spawnTime   time.Time
-	avatar      string
 }
 
 type Player struct {
-	Name        string     `json:"name"`
-	PokemonList []*Pokemon `json:"pokemon_list"`
-	pos         Position
-	avatar      string
-}
-type World struct {
-	size     int
-	grid     [][]interface{}
-	players  map[string]*Player
-	pokemons []*Pokemon
-	mux      sync.Mutex
+	Name         string          `json:"name"`
+	PasswordHash string          `json:"password_hash,omitempty"`
+	PokemonList  []*OwnedPokemon `json:"pokemon_list"`
+	Rating       int             `json:"rating"`
+	Wins         int             `json:"wins"`
+	Losses       int             `json:"losses"`
+	Draws        int             `json:"draws"`
+	History      []BattleRecord  `json:"history"`
+	pos          Position
+	avatar       string
 }
 type Participant struct {
 	player     *Player
 	turn       int
 	isWin      bool
-	curPokemon Pokemon
-	conn       net.Conn
+	curPokemon *OwnedPokemon
+	stages     map[string]int
+	potions    int
+	session    *Session
+	input      chan string
+	matched    chan struct{}
 	catchMode  bool
+	// reconnect state, guarded by mu
+	mu         sync.Mutex
+	token      string
+	connected  bool
+	gone       bool
+	kicked     bool
+	graceTimer *time.Timer
+	lastPrompt *Message
 }
 type Message struct {
-	msg  string
-	conn net.Conn
+	typ     protocol.Type
+	payload any
 }
 type Position struct {
 	X, Y int
 }
 
-const (
-	worldSize       = 25
-	spawTime        = 5 * time.Second
-	despawnTime     = 10 * time.Second
-	pokemonPerSpawn = 10
-	playerLink      = "./Assets/players.json"
-	maxPokemon      = 10
-)
-
 var (
-	participants []Participant
-	conns        []net.Conn
-	connCh       = make(chan net.Conn)
-	closeCh      = make(chan Participant)
-	msgCh        = make(chan string)
-	msgChOne     = make(chan Message)
+	participants []*Participant
+	connCh       = make(chan *Session)
+	closeCh      = make(chan *Participant)
 	starters     = []string{"Charmander", "Bulbasaur", "Squirtle"}
 	mu           sync.Mutex
-	writeMu      sync.Mutex
-	endCh        = make(chan string)
 	pokedex      []Pokemon
 	// moveCh        = make(chan string)
-	world         = newWorld(worldSize)
+	world         *World
 	avatarPokeman = []string{"🏃", "🚶", "🥷", "🙎", "🧛", "👨"}
 	// avatarPokemon = []string{"🔥", "🌿", "💧", "⛰️", "🪽", "⚡️"}
-	messages         []string
-	listofBattle     []Participant
-	existingPlayers1 []Player
+	playerRepo PlayerRepository
+	matchmaker = newMatchmaker()
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "preview" {
+		os.Exit(previewCommand(os.Args[2:]))
+	}
+	flag.String(config.FileFlag, "", "TOML file with the server settings (env "+config.EnvName(envPrefix, config.FileFlag)+")")
+	compactOnly := flag.Bool("compact", false, "compact the journal store and exit")
+	cfg.bindFlags(flag.CommandLine)
+	if err := config.Load(flag.CommandLine, os.Args[1:], envPrefix, &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "configuration: %v\n", err)
+		os.Exit(2)
+	}
+	cfg.resolve()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	matchmaker.byRating = cfg.Matchmaking == "rating"
+	terrain, err := worldTerrain(cfg.World)
+	if err != nil {
+		fatal("load world map", "err", err)
+	}
+	world = newWorld(cfg.World.Size, terrain)
+
+	// Load the players
+	playerRepo, err = newPlayerRepository(cfg.Store, cfg.dataPath(playersFile), cfg.JournalDir)
+	if err != nil {
+		fatal("open player store", "err", err)
+	}
+	if err := playerRepo.Load(); err != nil {
+		fatal("load players", "store", cfg.Store, "err", err)
+	}
+	slog.Info("players loaded", "store", cfg.Store)
+	if *compactOnly {
+		c, ok := playerRepo.(compactor)
+		if !ok {
+			fatal("the store does not support compaction", "store", cfg.Store)
+		}
+		if err := c.Compact(); err != nil {
+			fatal("compact player store", "err", err)
+		}
+		slog.Info("player store compacted")
+		return
+	}
 	// Create the world
 
 	// Start the server
-	server, err := net.Listen("tcp", ":3015")
+	server, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
-		log.Fatal(err)
+		fatal("listen", "addr", cfg.Listen, "err", err)
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled {
+		tlsConfig, err = loadTLSConfig(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			fatal("load TLS certificate", "err", err)
+		}
+		server = tls.NewListener(server, tlsConfig)
 	}
-	fmt.Println("server started")
+	slog.Info("server started", "addr", server.Addr().String(), "tls", cfg.TLS.Enabled)
 	// Load the Pokedex
-	file, _ := os.Open("./Assets/pokedex.json")
+	file, err := os.Open(cfg.dataPath(pokedexFile))
+	if err != nil {
+		fatal("open Pokedex", "err", err)
+	}
 	defer file.Close()
 	decoder := json.NewDecoder(file)
-	_ = decoder.Decode(&pokedex)
-	// fmt.Println(pokedex)
-	fmt.Println("Pokedex loaded")
+	if err := decoder.Decode(&pokedex); err != nil {
+		fatal("decode Pokedex", "file", file.Name(), "err", err)
+	}
+	indexPokedex()
+	if err := loadEvolutions(cfg.dataPath(evolutionsFile)); err != nil {
+		fatal("load evolutions", "err", err)
+	}
+	if err := loadTypeChart(cfg.dataPath(typeChartFile)); err != nil {
+		fatal("load type chart", "err", err)
+	}
+	if err := loadMoves(cfg.dataPath(movesFile)); err != nil {
+		fatal("load moves", "err", err)
+	}
+	if err := loadBiomes(cfg.dataPath(biomesFile)); err != nil {
+		fatal("load biomes", "err", err)
+	}
+	slog.Info("Pokedex loaded", "species", len(pokedex))
+	if err := bans.load(cfg.dataPath(bansFile)); err != nil {
+		fatal("load bans", "err", err)
+	}
+	listeners := []io.Closer{server}
+	if cfg.Admin != "" {
+		admin, err := startAdmin(cfg.Admin)
+		if err != nil {
+			fatal("start admin console", "addr", cfg.Admin, "err", err)
+		}
+		listeners = append(listeners, admin)
+	}
+	var httpServer *http.Server
+	if cfg.HTTP != "" {
+		if httpServer, err = startHTTP(cfg.HTTP, tlsConfig); err != nil {
+			fatal("start HTTP server", "addr", cfg.HTTP, "err", err)
+		}
+	}
 
 	// Accept incoming connections
 	go func() {
 		for {
 			conn, err := server.Accept()
-			if err != nil {
-				log.Fatal(err)
-			}
-			mu.Lock()
-			conns = append(conns, conn)
-			mu.Unlock()
-			connCh <- conn
-		}
-	}()
-	// display the battle
-	go func() {
-		for {
-			listofBattle = listOfBattleMode(participants)
-			if len(listofBattle) == 2 {
-				// Start the battle for participants in battle mode
-				winner, loser := battle(&listofBattle[0], &listofBattle[1])
-				saveWinner(winner.player)
-				msg := fmt.Sprintf("\n🔴%s wins the battle - %s lost\n", winner.player.Name, loser.player.Name)
-				for _, p := range listofBattle {
-					msgChOne <- Message{msg: msg + "#", conn: p.conn}
-				}
-				// remove all connections
-				for _, p := range listofBattle {
-					closeCh <- p
-				}
-
-			}
-		}
-	}()
-	// Display the world
-	go func() {
-		// check if there are any players in the game
-		for {
-			time.Sleep(spawTime)
-			// check if there are any players in the game
-			isCatchMode := false
-			var i int
-			var p Participant
-			for i, p = range participants {
-				if p.catchMode {
-					isCatchMode = true
-				}
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
-			// Spawn Pokémon randomly every few seconds
-			if isCatchMode {
-				go func() {
-					for {
-						time.Sleep(spawTime)
-						world.spawnPokemonWave()
-						if len(listOfCatchMode(participants)) > 0 {
-							for _, p := range listOfCatchMode(participants) {
-								msgChOne <- Message{msg: world.display(), conn: p.conn}
-							}
-						}
-					}
-				}()
-
-				// Despawn Pokémon every minute
-				go func() {
-					for {
-						time.Sleep(despawnTime)
-						world.deSpawnPokemons()
-
-						if len(listOfCatchMode(participants)) > 0 {
-							for _, p := range listOfCatchMode(participants) {
-								msgChOne <- Message{msg: world.display(), conn: p.conn}
-							}
-						}
-					}
-				}()
-				// Wait for the game to end
-				end := <-endCh
-				isCatchMode = false
-
-				fmt.Printf("%s %s catching game\n", participants[i].player.Name, end)
+			if err != nil {
+				slog.Warn("accept", "err", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
 			}
+			connCh <- newSession(conn, protocol.NewConn(conn))
 		}
 	}()
-
+	// pair battle-mode players and run their battles
+	go matchmaker.run()
+	// spawn and despawn wild Pokemon while anybody is catching
+	go runWorld(world)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var stopped chan struct{} // set once a shutdown is under way
 	for {
 		select {
-		case conn := <-connCh:
-			go onMessage(conn, pokedex)
-
-		case msg := <-msgCh:
-			fmt.Print(msg)
-			publishMsgAll(msg)
+		case sig := <-signals:
+			if stopped != nil {
+				fatal("stopping at once on a second signal", "signal", sig.String())
+			}
+			slog.Info("shutting down", "signal", sig.String(), "timeout", cfg.ShutdownTimeout)
+			stopped = make(chan struct{})
+			go func() {
+				shutdown(listeners, httpServer)
+				close(stopped)
+			}()
+		case <-stopped:
+			slog.Info("server stopped")
+			return
+		case session := <-connCh:
+			go onMessage(session, pokedex)
 
 		case participant := <-closeCh:
-			fmt.Printf("%s exit\n", participant.player.Name)
+			slog.Info("player left", "player", participant.player.Name)
 			removeParticipant(participant)
 			// remove player from the world
 			if participant.catchMode {
-				world.mux.Lock()
-				delete(world.players, participant.player.Name)
-				world.grid[participant.player.pos.X][participant.player.pos.Y] = nil
-				world.mux.Unlock()
+				world.removePlayer(participant.player.Name)
+				world.savePlayer(participant.player)
 			}
-
-		case msg := <-msgChOne:
-			fmt.Print(msg.msg)
-			publishMsgOne(msg.conn, msg.msg)
 		}
 	}
 
 }
-func newWorld(size int) *World {
-	grid := make([][]interface{}, size)
-	for i := range grid {
-		grid[i] = make([]interface{}, size)
-	}
-	// spawn pokemon
 
-	return &World{
-		size:     size,
-		grid:     grid,
-		players:  make(map[string]*Player),
-		pokemons: []*Pokemon{},
+// runWorld spawns a wave of wild Pokemon every spawn interval while at least
+// one player is catching, and despawns the expired ones, until the server
+// stops.
+func runWorld(w *World) {
+	spawn := time.NewTicker(cfg.World.SpawnInterval)
+	defer spawn.Stop()
+	despawn := time.NewTicker(cfg.World.DespawnAfter)
+	defer despawn.Stop()
+	for {
+		select {
+		case <-spawn.C:
+			mu.Lock()
+			catching := len(listOfCatchMode(participants)) > 0
+			mu.Unlock()
+			if catching {
+				w.spawnPokemonWave()
+			}
+		case <-despawn.C:
+			w.deSpawnPokemons()
+		case <-stopping:
+			return
+		}
 	}
 }
-func listOfCatchMode(participants []Participant) []Participant {
+
+func listOfCatchMode(participants []*Participant) []*Participant {
 	// return the list of participants in catch mode
-	var catchModeParticipants []Participant
+	var catchModeParticipants []*Participant
 	for _, p := range participants {
 		if p.catchMode {
 			catchModeParticipants = append(catchModeParticipants, p)
@@ -252,406 +290,210 @@ func listOfCatchMode(participants []Participant) []Participant {
 	}
 	return catchModeParticipants
 }
-func listOfBattleMode(participants []Participant) []Participant {
-	// return the list of participants in battle mode
-	var battleModeParticipants []Participant
-	if len(participants) > 0 {
-		for _, p := range participants {
-			if !p.catchMode {
-				battleModeParticipants = append(battleModeParticipants, p)
-			}
-		}
-	}
-	return battleModeParticipants
-}
-func (w *World) addPlayer(name string, x int, y int) *Player {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	pos := Position{x, y}
-	playerAvatar := avatarPokeman[len(avatarPokeman)-1]
-	avatarPokeman = avatarPokeman[:len(avatarPokeman)-1]
-	// Check if there's another player at the initial position
-	for {
-		if w.grid[pos.X][pos.Y] != nil {
-			pos.X = (pos.X + 1) % w.size
-			pos.Y = (pos.Y + 1) % w.size
-		} else {
-			break
-		}
-	}
-
-	// get pokemonlist from json file
-	file, _ := os.Open(playerLink)
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	existingPlayers := []Player{}
-	_ = decoder.Decode(&existingPlayers)
-	for _, existingPlayer := range existingPlayers {
-		if existingPlayer.Name == name {
-			w.players[name] = &Player{Name: name, PokemonList: existingPlayer.PokemonList, pos: pos, avatar: playerAvatar}
-			w.grid[pos.X][pos.Y] = w.players[name]
-			return w.players[name]
-		}
-	}
-	// if player is not in the json file
-	player := &Player{Name: name, pos: pos, PokemonList: []*Pokemon{}, avatar: playerAvatar}
-	w.players[name] = player
-	w.grid[pos.X][pos.Y] = player
-	return player
-}
-func (w *World) movePlayer(name string, dx, dy int) {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	player, ok := w.players[name]
-	if !ok {
-		return
-	}
-	// Save the player's old position
-	oldX := (player.pos.X + w.size) % w.size
-	oldY := (player.pos.Y + w.size) % w.size
-	newX := (player.pos.X + dx + w.size) % w.size
-	newY := (player.pos.Y + dy + w.size) % w.size
-	// Move forward
-	w.grid[player.pos.X][player.pos.Y] = nil
-	x := newX
-	y := newY
-	// Check if there's another player at the new position
-	if _, ok := w.grid[x][y].(*Player); ok {
-		x = oldX
-		y = oldY
-		// fmt.Println("There's another player at the new position. You can't move there.")
-		msgCh <- fmt.Sprintf("There's another player at the new position. %s can't move there.#", player.Name)
-		time.Sleep(2 * time.Second)
-	}
-	// Check if there's a Pokémon at the new position
-	if p, ok := w.grid[x][y].(*Pokemon); ok {
-		if len(player.PokemonList) <= maxPokemon {
-			// fmt.Printf("%s captured %s!\n", player.Name, p.Name)
-			msgCh <- fmt.Sprintf("%s captured %s!\n#", player.Name, p.Name)
-			player.PokemonList = append(player.PokemonList, p)
-			w.removePokemon(p)
-			// Remove player from old position
-			savePlayerData(*player)
-			time.Sleep(2 * time.Second)
-		} else {
-			x = oldX
-			y = oldY
-			// fmt.Println("You have reached the maximum number of Pokémon. You can't capture more.")
-			msgCh <- fmt.Sprintf("You have reached the maximum number of Pokémon. %s can't capture more.#", player.Name)
-			time.Sleep(2 * time.Second)
-		}
-	}
 
-	// Update player position
-	player.pos.X = x
-	player.pos.Y = y
-
-	// Place player in new position
-	w.grid[player.pos.X][player.pos.Y] = player
-}
+func removeParticipant(participant *Participant) {
+	participant.leave()
+	releaseAccount(participant.player.Name)
+	audit.Info("leave", "player", participant.player.Name, "catch", participant.catchMode)
 
-func (w *World) display() string {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	var message string
-	// Clear the screen
-	message += "\n\n\n"
-	for i := 0; i < w.size; i++ {
-		for j := 0; j < w.size; j++ {
-			if w.grid[i][j] != nil {
-				switch w.grid[i][j].(type) {
-				case *Player:
-					fmt.Print(w.grid[i][j].(*Player).avatar)
-					message += w.grid[i][j].(*Player).avatar
-				case *Pokemon:
-					fmt.Print(w.grid[i][j].(*Pokemon).avatar)
-					message += w.grid[i][j].(*Pokemon).avatar
-				}
-			} else {
-				fmt.Print("￭ ")
-				message += "￭ "
-			}
-		}
-		fmt.Println()
-		message += "\n"
-	}
-	fmt.Println()
-	message += "\n#"
-	return message
-}
-func (w *World) spawnPokemonWave() {
-	// Ensure n is greater than 0
-	n := len(pokedex)
-	if n <= 0 {
-		fmt.Println("No pokemons to spawn")
-		return
-	}
-	for i := 0; i < pokemonPerSpawn; i++ {
-		// Generate random x and y coordinates for the Pokemon
-		x := rand.Intn(w.size)
-		y := rand.Intn(w.size)
-		// check if there's another entity at the initial position
-		for {
-			if w.grid[x][y] != nil {
-				x = (x + 1) % w.size
-				y = (y + 1) % w.size
-			} else {
-				break
-			}
-		}
-		pokemon := &pokedex[rand.Intn(len(pokedex))]
-		// Create a new Pokemon
-		pos := Position{x, y}
-		pokemon.pos = pos
-		pokemon.spawnTime = time.Now()
-		// Randomly generate the EV points
-		pokemon.EVPoints = math.Round((0.5+rand.Float64()/2)*100) / 100
-		// assign avatar
-		// switch pokemon.Type[0] {
-		// case "fire":
-		// 	pokemon.avatar = avatarPokemon[0]
-		// case "grass":
-		// 	pokemon.avatar = avatarPokemon[1]
-		// case "water":
-		// 	pokemon.avatar = avatarPokemon[2]
-		// case "rock":
-		// 	pokemon.avatar = avatarPokemon[3]
-		// case "Flying":
-		// 	pokemon.avatar = avatarPokemon[4]
-		// case "electric":
-		// 	pokemon.avatar = avatarPokemon[5]
-		// }
-		pokemon.avatar = "🐼"
-
-		// Add the Pokemon to the world
-		w.grid[x][y] = pokemon
-		w.pokemons = append(w.pokemons, pokemon)
-	}
-}
-
-func (w *World) removePokemon(p *Pokemon) {
-	for i, pokemon := range w.pokemons {
-		if pokemon == p {
-			w.pokemons = append(w.pokemons[:i], w.pokemons[i+1:]...)
-			break
-		}
-	}
-	w.grid[p.pos.X][p.pos.Y] = nil
-}
-
-func savePlayerData(player Player) {
-	// Load the existing players from the JSON file
-	file, _ := os.Open(playerLink)
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-
-	_ = decoder.Decode(&existingPlayers1)
-	// condition to check new player
-	isNewPlayer := false
-	// Find the existed player in the list and update their data
 	mu.Lock()
-	for i, existingPlayer := range existingPlayers1 {
-		if existingPlayer.Name == player.Name {
-			existingPlayers1[i] = player
+	for i := range participants {
+		if participants[i] == participant {
+			participants = append(participants[:i], participants[i+1:]...)
 			break
 		}
 	}
 	mu.Unlock()
-	// Save new player data to the JSON file
-	if !isNewPlayer {
-		existingPlayers1 = append(existingPlayers1, player)
-	}
-	file, _ = os.Create(playerLink)
-	defer file.Close()
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "    ") // Set indent to 4 spaces
-	_ = encoder.Encode(existingPlayers1)
-
-	// fmt.Printf("Player %s saved\n", player.Name)
-	msgCh <- fmt.Sprintf("Player %s saved\n#", player.Name)
-
-}
-func (w *World) deSpawnPokemons() {
-	w.mux.Lock()
-	defer w.mux.Unlock()
-	now := time.Now()
-	for _, p := range w.pokemons {
-		if now.Sub(p.spawnTime) >= despawnTime-time.Second*2 {
-			// fmt.Printf("%s despawned\n", p.Name)
-			w.removePokemon(p)
-		}
+	// close the connection once everything queued for it is written
+	session := participant.currentSession()
+	session.Send(bye("game over"))
+	session.Close()
+}
+
+func onMessage(session *Session, pokedex []Pokemon) {
+	slog.Debug("client connected", "remote", session.netConn.RemoteAddr().String())
+	conn := session.conn
+	session.netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.ServerHandshake("pokeGame"); err != nil {
+		slog.Info("handshake failed", "remote", session.netConn.RemoteAddr().String(), "err", err)
+		session.teardown()
+		return
 	}
-}
-
-func removeParticipant(participant Participant) {
+	session.register()
 
-	for i := range participants {
-		if participants[i].player.Name == participant.player.Name {
-			mu.Lock()
-			participants = append(participants[:i], participants[i+1:]...)
-			mu.Unlock()
-			break
+	remote := session.netConn.RemoteAddr().String()
+	var player *Player
+	var method, attempted string
+	limiter := &loginLimiter{}
+	for player == nil {
+		env, err := conn.Receive()
+		if err != nil {
+			session.teardown()
+			return
+		}
+		if env.Type != protocol.TypeLogin && env.Type != protocol.TypeRegister {
+			session.Send(errorMessage(protocol.ErrBadRequest, "please log in first"))
+			continue
+		}
+		if wait := limiter.wait(); wait > 0 {
+			session.Send(errorMessage(protocol.ErrTooManyAttempts, fmt.Sprintf("Too many attempts, try again in %s.", wait.Round(time.Second))))
+			continue
+		}
+		if env.Type == protocol.TypeRegister {
+			var register protocol.Register
+			if err = env.Decode(&register); err == nil {
+				method, attempted = "register", strings.TrimSpace(register.Name)
+				player, err = registerAccount(attempted, register.Password)
+			}
+		} else {
+			var login protocol.Login
+			if err = env.Decode(&login); err == nil {
+				method, attempted = "password", strings.TrimSpace(login.Name)
+				if login.ResumeToken != "" {
+					method = "resume"
+					if err = resumeParticipant(session, strings.TrimSpace(login.Name), login.ResumeToken); err == nil {
+						return
+					}
+					err = &authError{protocol.ErrResumeFailed, err.Error()}
+				} else {
+					player, err = authenticate(strings.TrimSpace(login.Name), login.Password)
+				}
+			}
 		}
-	}
-	// Remove from conns
-	for i, conn := range conns {
-		if conn == participant.conn {
-			mu.Lock()
-			conns = append(conns[:i], conns[i+1:]...)
-			mu.Unlock()
-			break
+		if err != nil {
+			code := protocol.ErrBadRequest
+			if authErr, ok := err.(*authError); ok {
+				code = authErr.code
+			}
+			session.Send(errorMessage(code, err.Error()))
+			audit.Info("login_failed", "player", attempted, "method", method, "code", code, "remote", remote)
+			if limiter.fail() {
+				session.Send(errorMessage(protocol.ErrTooManyAttempts, "Too many failed attempts, goodbye."))
+				session.Close()
+				return
+			}
+			continue
 		}
-	}
-}
-
-// func publishMsgExcept(conn net.Conn, msg string) {
-// 	for i := range conns {
-// 		if conns[i] != conn {
-// 			writer := bufio.NewWriter(conns[i])
-// 			writer.WriteString(msg)
-// 			writer.Flush()
-// 		}
-// 	}
-// }
-
-func publishMsgOne(conn net.Conn, msg string) error {
-	writeMu.Lock()
-	defer writeMu.Unlock()
-
-	if _, err := conn.Write([]byte(msg)); err != nil {
-		return err
-	}
-	return nil
-}
-
-func publishMsgAll(msg string) error {
-	writeMu.Lock()
-	defer writeMu.Unlock()
-
-	for i := range conns {
-		if _, err := conns[i].Write([]byte(msg)); err != nil {
-			return err
+		if !claimAccount(player.Name) {
+			// the password proves who it is, so a dropped session is handed over
+			if p := findParticipant(player.Name); p != nil && !p.isConnected() {
+				if resumeParticipant(session, p.player.Name, p.token) == nil {
+					return
+				}
+			}
+			session.Send(errorMessage(protocol.ErrAlreadyOnline, fmt.Sprintf("%s is already playing in another session.", player.Name)))
+			player = nil
 		}
-
 	}
-	return nil
-}
-
-func onMessage(conn net.Conn, pokedex []Pokemon) {
-	fmt.Println("A client connected")
-	var playerName string
+	playerName := player.Name
+	session.setAccount(playerName)
+	audit.Info("login", "player", playerName, "method", method, "remote", remote)
+	token := newResumeToken()
+	session.Send(Message{typ: protocol.TypeLoginOK, payload: protocol.LoginOK{Name: playerName, ResumeToken: token}})
 	var mode string
-
-	for {
-		reader := bufio.NewReader(conn)
-		input, err := reader.ReadString('\n')
+	for mode == "" {
+		env, err := conn.Receive()
 		if err != nil {
-			log.Fatal(err)
+			releaseAccount(playerName)
+			session.teardown()
+			return
 		}
-		// separate the name and mode
-		input = strings.TrimSpace(input)
-		playerName = strings.Split(input, " ")[0]
-		mode = strings.Split(input, " ")[1]
-
-		playerExists := false
-		for _, p := range participants {
-			if p.player.Name == playerName {
-				publishMsgOne(conn, "Player already exists. Please choose another name.\n#")
-				playerExists = true
-				break
-			}
-		}
-
-		if !playerExists {
-			break
+		var selected protocol.ModeSelect
+		if env.Type != protocol.TypeModeSelect || env.Decode(&selected) != nil ||
+			(selected.Mode != protocol.ModeBattle && selected.Mode != protocol.ModeCatch) {
+			session.Send(errorMessage(protocol.ErrBadRequest, "please select the battle or catch mode"))
+			continue
 		}
+		mode = selected.Mode
 	}
-	// mode = 1 for battle mode
-	// mode = 2 for catch mode
-	if mode == "1" {
-		playerName = strings.TrimSpace(playerName)
-
-		fmt.Println(playerName)
-
-		player, found := findPlayer(playerName)
-		if !found {
-			publishMsgOne(conn, "Player does not exist. Created a new player.\n")
-			player = createPlayer(pokedex, playerName)
-		}
+	audit.Info("mode", "player", playerName, "mode", mode)
+	if mode == protocol.ModeBattle {
+		bindRoster(player)
 		// request the player to choose a Pokemon
-		// make all the Pokemon deployable
+		// heal all the Pokemon and make them deployable
 		for i := range player.PokemonList {
-			player.PokemonList[i].Deployable = true
+			player.PokemonList[i].heal()
 		}
 		msg := getListOfPokemon(player.PokemonList)
-
-		chosenPokemon, _ := readPokemonFromClient(conn, msg[:len(msg)-1]+"Choose a pokemon: #", player.PokemonList)
+		participant := &Participant{
+			player:    player,
+			turn:      cfg.Battle.Faints,
+			isWin:     false,
+			session:   session,
+			input:     make(chan string),
+			matched:   make(chan struct{}, 1),
+			catchMode: false,
+			token:     token,
+			connected: true,
+		}
+		go readInputs(participant)
+		chosenPokemon, left := choosePokemon(participant, msg+"Choose a pokemon: ", 0)
+		if left || chosenPokemon == nil {
+			slog.Info("player left before choosing a Pokemon", "player", playerName)
+			releaseAccount(playerName)
+			session.Close()
+			return
+		}
+		participant.sendOut(chosenPokemon)
 
 		// Add the player to the list of participants
-		mu.Lock()
-		participants = append(participants, Participant{
-			player:     player,
-			turn:       3,
-			isWin:      false,
-			curPokemon: chosenPokemon,
-			conn:       conn,
-			catchMode:  false,
-		})
-		mu.Unlock()
-		fmt.Println("The number of connected participants: ", len(participants))
-
-	} else if mode == "2" {
-		// create a new player
-		playerName = strings.TrimSpace(playerName)
+		joinParticipants(participant)
+		matchmaker.Enqueue(participant)
+		waitForMatch(participant)
+
+	} else {
 		// random position
-		xRan := rand.Intn(worldSize)
-		yRan := rand.Intn(worldSize)
-		fmt.Println(playerName)
+		xRan := rand.Intn(cfg.World.Size)
+		yRan := rand.Intn(cfg.World.Size)
 		player := world.addPlayer(playerName, xRan, yRan)
 		// Add the player to the list of participants
-		mu.Lock()
-		participants = append(participants, Participant{
+		participant := &Participant{
 			player:    player,
-			conn:      conn,
+			session:   session,
 			catchMode: true,
-		})
-		mu.Unlock()
-		fmt.Println("The number of connected participants: ", len(participants))
-		go handlePlayerMovement(conn, world, playerName)
+			token:     token,
+			connected: true,
+		}
+		joinParticipants(participant)
+		world.watch(participant)
+		go handlePlayerMovement(participant, world)
 	}
 }
 
-func createPlayer(pokedex []Pokemon, playerName string) *Player {
+// joinParticipants adds a participant that is ready to play.
+func joinParticipants(p *Participant) {
+	mu.Lock()
+	participants = append(participants, p)
+	n := len(participants)
+	mu.Unlock()
+	slog.Info("player joined", "player", p.player.Name, "catch", p.catchMode, "participants", n)
+}
+
+func createPlayer(pokedex []Pokemon, playerName, passwordHash string) (*Player, error) {
 	// Create the player
 
-	player := Player{
-		Name:        playerName,
-		PokemonList: []*Pokemon{},
+	player := &Player{
+		Name:         playerName,
+		PasswordHash: passwordHash,
+		PokemonList:  []*OwnedPokemon{},
 	}
 	// Choose 3 starter Pokemon
 	for _, p := range starters {
-		pokemon, _ := findPokemon(pokedex, p)
-		player.PokemonList = append(player.PokemonList, &pokemon)
+		species, ok := findPokemon(pokedex, p)
+		if !ok {
+			continue
+		}
+		pokemon := newPokemonInstance(species, 1)
+		pokemon.CaughtAt = time.Now()
+		player.PokemonList = append(player.PokemonList, pokemon)
+	}
+	// Save the new player
+	if err := playerRepo.Save(player); err != nil {
+		return nil, err
 	}
-	// Load the existing players from the JSON file
-	file, _ := os.Open(playerLink)
-	decoder := json.NewDecoder(file)
-	existingPlayers := []Player{}
-	_ = decoder.Decode(&existingPlayers)
-	file.Close()
-
-	// Append the new player to the list
-	existingPlayers = append(existingPlayers, player)
-
-	// Save the updated list of players to the JSON file
-	file, _ = os.Create(playerLink)
-	defer file.Close()
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "    ") // Set indent to 4 spaces
-	_ = encoder.Encode(existingPlayers)
 
-	fmt.Printf("Player %s created\n", playerName)
-	return &player
+	slog.Info("player created", "player", playerName)
+	return player, nil
 }
 
 // func contains(s []string, str string) bool {
@@ -663,45 +505,59 @@ func createPlayer(pokedex []Pokemon, playerName string) *Player {
 // 	return false
 // }
 
-func findPokemon(pokedex []Pokemon, name string) (Pokemon, bool) {
-	for _, p := range pokedex {
-		if strings.EqualFold(p.Name, name) {
-			return p, true
+func findPokemon(pokedex []Pokemon, name string) (*Pokemon, bool) {
+	for i := range pokedex {
+		if strings.EqualFold(pokedex[i].Name, name) {
+			return &pokedex[i], true
 		}
 	}
-	return Pokemon{}, false
+	return nil, false
 }
-func findPlayer(name string) (*Player, bool) {
-	// Load the Pokedex
-	file, _ := os.Open(playerLink)
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	players := []Player{}
-	_ = decoder.Decode(&players)
-	// Find the player in the list with the given name and has the most of length of pokemonlist
-	maxLen := 0
-	var maxPlayer *Player
-	for _, p := range players {
-		if strings.EqualFold(p.Name, name) && len(p.PokemonList) > maxLen {
-			maxLen = len(p.PokemonList)
-			maxPlayer = &p
-		}
+
+// calculateDamage rolls accuracy and critical hits and scales the move
+// power by the attack/defense ratio, level, stat stages, same-type bonus
+// and the type chart.
+func calculateDamage(attacker, defender *Participant, move *Move) attackResult {
+	if move.Accuracy > 0 && rand.Intn(100) >= move.Accuracy {
+		return attackResult{missed: true, effectiveness: 1}
 	}
-	if maxPlayer != nil {
-		return maxPlayer, true
+	if move.Category == "status" {
+		return attackResult{effectiveness: 1}
 	}
-	return &Player{}, false
-}
-func calculateDamage(attacker, defender *Pokemon, attackType string) int {
-	if attackType == "😌normal" {
-		return max(attacker.Attack-defender.Defense, 0)
+	var attack, defense float64
+	if move.Category == "special" {
+		attack = float64(attacker.curPokemon.SpAttack) * attacker.stageMultiplier("sp_attack")
+		defense = float64(defender.curPokemon.SpDefense) * defender.stageMultiplier("sp_defense")
+	} else {
+		attack = float64(attacker.curPokemon.Attack) * attacker.stageMultiplier("attack")
+		defense = float64(defender.curPokemon.Defense) * defender.stageMultiplier("defense")
 	}
-	if attackType == "💥special" {
-		elementalMultiplier := 1.75
+	defense = math.Max(defense, 1)
 
-		return max(int(float64(attacker.SpAttack)*elementalMultiplier)-defender.SpDefense, 0)
+	effectiveness := typeChart.effectiveness(move.Type, defender.curPokemon.Types())
+	if effectiveness == 0 {
+		return attackResult{effectiveness: 0}
+	}
+	multiplier := effectiveness
+	if hasType(attacker.curPokemon.Types(), move.Type) {
+		multiplier *= sameTypeBonus
+	}
+	critical := rand.Intn(criticalChance) == 0
+	if critical {
+		multiplier *= criticalBonus
+	}
+	multiplier *= 0.85 + rand.Float64()*0.15
+
+	// stats are close to the base stats, so the power is divided by 5
+	// instead of the usual level-heavy formula to keep battles short
+	level := float64(attacker.curPokemon.Level)
+	base := float64(move.Power)*attack/defense*(1+level/50)/5 + 2
+	// a hit that lands always does at least 1 damage
+	return attackResult{
+		damage:        max(int(base*multiplier), 1),
+		effectiveness: effectiveness,
+		critical:      critical,
 	}
-	return 0
 }
 
 // Helper function to get the maximum of two integers
@@ -711,251 +567,94 @@ func max(a, b int) int {
 	}
 	return b
 }
-func handlePlayerMovement(conn net.Conn, world *World, playerName string) {
-	fmt.Println("Player movement handler started")
-	reader := bufio.NewReader(conn)
+
+// handlePlayerMovement reads the moves of a catch-mode participant until it
+// quits or its connection breaks.
+func handlePlayerMovement(participant *Participant, world *World) {
+	session := participant.currentSession()
+	playerName := participant.player.Name
 	go func() {
+		// the wild battle being fought, fed with the Input frames; it
+		// does not outlive the connection
+		var fight *encounter
+		fighting := func() bool {
+			return fight != nil && !fight.over()
+		}
 		for {
-			input, err := reader.ReadString('\n')
+			env, err := session.conn.Receive()
 			if err != nil {
-				log.Fatal(err)
-			}
-			input = strings.TrimSpace(input)
-			switch input {
-			case string(rune(keyboard.KeyArrowUp)):
-				world.movePlayer(playerName, -1, 0)
-			case string(rune(keyboard.KeyArrowDown)):
-				world.movePlayer(playerName, 1, 0)
-			case string(rune(keyboard.KeyArrowLeft)):
-				world.movePlayer(playerName, 0, -1)
-			case string(rune(keyboard.KeyArrowRight)):
-				world.movePlayer(playerName, 0, 1)
-			case string(rune(keyboard.KeyEsc)):
-				endCh <- "ended"
-				for _, p := range participants {
-					if p.player.Name == playerName {
-						closeCh <- p
-					}
+				if fighting() {
+					fight.leave()
 				}
+				participant.disconnect(session)
 				return
 			}
-			for _, p := range listOfCatchMode(participants) {
-				msgChOne <- Message{msg: world.display(), conn: p.conn}
+			switch env.Type {
+			case protocol.TypeChat:
+				relayChat(session, playerName, env)
+				continue
+			case protocol.TypeInput:
+				var input protocol.Input
+				if err := env.Decode(&input); err != nil {
+					session.Send(errorMessage(protocol.ErrBadRequest, err.Error()))
+				} else if fighting() {
+					fight.feed(strings.TrimSpace(input.Text))
+				} else {
+					session.Send(errorMessage(protocol.ErrBadRequest, "there is no question to answer, walk into a Pokémon to fight it"))
+				}
+				continue
 			}
-		}
-	}()
-
-}
-
-// Battle function
-func battle(participant1, participant2 *Participant) (*Participant, *Participant) {
-	var surrendered bool
-	for {
-
-		// Start the battle
-		winner, loser := battleRound(participant1, participant2)
-		fmt.Println("winner", winner.player.Name)
-		fmt.Println("loser", loser.player.Name)
-		msg := fmt.Sprintf("\n👉 %s wins the round - %s lost. Let's %s choose another pokemon!\n", winner.player.Name, loser.player.Name, loser.player.Name)
-		messages = append(messages, msg)
-		// msgCh <- msg + "#"
-		if loser.turn <= 0 {
-
-			// msgCh <- msg + "#"
-			messages = append(messages, fmt.Sprintf("\nBATTLE END!!! \n%s has no turns left. %s wins!", loser.player.Name, winner.player.Name))
-			totalExp := 0
-			for _, pokemon := range loser.player.PokemonList {
-				totalExp += pokemon.Exp
+			var move protocol.Move
+			if env.Type != protocol.TypeMove || env.Decode(&move) != nil {
+				session.Send(errorMessage(protocol.ErrBadRequest, "only moves, answers and chat are accepted while catching"))
+				continue
 			}
-
-			// Distribute the total experience to the winning team
-			expPerPokemon := totalExp / 3
-			for i := range winner.player.PokemonList {
-				winner.player.PokemonList[i].AccumExp += expPerPokemon
+			if fighting() && move.Direction != protocol.DirectionQuit {
+				session.Send(notice("You are fighting a wild Pokémon, type r to run away first.\n"))
+				continue
 			}
-			for _, p := range listOfBattleMode(participants) {
-				msgChOne <- Message{msg: strings.Join(messages, "") + "#", conn: p.conn}
-			}
-			messages = []string{}
-			return winner, loser
-
-		}
-		// Ask the losing participant to choose another Pokemon
-		pokemonList := getListOfPokemon(loser.player.PokemonList)[:len(getListOfPokemon(loser.player.PokemonList))-1]
-		for _, p := range listOfBattleMode(participants) {
-
-			msgChOne <- Message{msg: strings.Join(messages, "") + "#", conn: p.conn}
-
-		}
-
-		messages = []string{}
-		loser.curPokemon, surrendered = readPokemonFromClient(loser.conn, "\nYou lost the round, Let's choose another Pokemon\n"+pokemonList+"PRESS -1 to surrender - Your choice: #", loser.player.PokemonList)
-		if surrendered {
-			totalExp := 0
-			for _, pokemon := range loser.player.PokemonList {
-				totalExp += pokemon.Exp
+			var dx, dy int
+			switch move.Direction {
+			case protocol.DirectionUp:
+				dx = -1
+			case protocol.DirectionDown:
+				dx = 1
+			case protocol.DirectionLeft:
+				dy = -1
+			case protocol.DirectionRight:
+				dy = 1
+			case protocol.DirectionQuit:
+				if fighting() {
+					fight.leave()
+				}
+				closeCh <- participant
+				return
+			default:
+				continue
 			}
-
-			// Distribute the total experience to the winning team
-			expPerPokemon := totalExp / 3
-			for i := range winner.player.PokemonList {
-				winner.player.PokemonList[i].Exp += expPerPokemon
+			result, wild := world.movePlayer(playerName, dx, dy)
+			switch result {
+			case "moved", "wall":
+				continue
+			case "encounter":
+				fight = startEncounter(participant, wild)
+				continue
+			case "blocked":
+				session.Send(notice("There's another player at the new position. You can't move there.\n"))
+			case "team_full":
+				session.Send(notice("You have reached the maximum number of Pokémon. You can't capture more.\n"))
 			}
-			return winner, loser
-		}
-	}
-}
-func battleRound(participant1, participant2 *Participant) (*Participant, *Participant) {
-
-	// Announce the current Pokemon
-	msg := fmt.Sprintf("---%s chose %s\n%s chose %s\n", participant1.player.Name, participant1.curPokemon.Name, participant2.player.Name, participant2.curPokemon.Name)
-
-	messages = append(messages, msg)
-	messages = append(messages, "------------BATTLE REPORT------------\n")
-
-	var winner, loser *Participant
-	var attacker, defender *Participant
-	if participant1.curPokemon.Speed > participant2.curPokemon.Speed {
-		attacker = participant1
-		defender = participant2
-	} else if participant1.curPokemon.Speed < participant2.curPokemon.Speed {
-		attacker = participant2
-		defender = participant1
-	} else {
-		// If the speeds are equal, randomly choose the attacker
-		if rand.Intn(2) == 0 {
-			attacker = participant1
-			defender = participant2
-		} else {
-			attacker = participant2
-			defender = participant1
+			// a pause after bumping into something, without holding up the world
+			time.Sleep(2 * time.Second)
 		}
-	}
-	fmt.Println("attacker: ", attacker.curPokemon.Name)
-	fmt.Println("defender: ", defender.curPokemon.Name)
-	messages = append(messages, fmt.Sprintf("🥾 %s will attack first.\n", attacker.player.Name))
-	for attacker.curPokemon.HP > 0 && defender.curPokemon.HP > 0 {
-
-		// Player's turn to choose attack type
-		attackTypes := []string{"😌normal", "💥special"}
-		attackType := attackTypes[rand.Intn(len(attackTypes))]
-
-		// Calculate and apply damage
-		dmg := calculateDamage(&attacker.curPokemon, &defender.curPokemon, attackType)
-		defender.curPokemon.HP -= dmg
-		fmt.Printf("%s attacked %s with %s attack dealing %d damage.\n", attacker.curPokemon.Name, defender.curPokemon.Name, attackType, dmg)
-		msg := fmt.Sprintf("%s attacked %s with %s attack dealing %d damage.\n", attacker.curPokemon.Name, defender.curPokemon.Name, attackType, dmg)
-
-		messages = append(messages, msg)
-		if defender.curPokemon.HP <= 0 {
-			fmt.Printf("➜ %s fainted.\n", defender.curPokemon.Name)
-			msg := fmt.Sprintf("➜ %s fainted.\n", defender.curPokemon.Name)
-			defender.turn--
-			for i := range defender.player.PokemonList {
-				if defender.player.PokemonList[i].Name == defender.curPokemon.Name {
-					// update hp to 0
-					defender.player.PokemonList[i].HP = 0
-					// update deployable
-					defender.player.PokemonList[i].Deployable = false
-				} else {
-					// update hp winner
-
-					for j := range attacker.player.PokemonList {
-						if attacker.player.PokemonList[j].Name == attacker.curPokemon.Name {
-							attacker.player.PokemonList[j].HP = attacker.curPokemon.HP
-						}
-					}
-
-				}
-			}
+	}()
 
-			loser = defender
-			winner = attacker
-			messages = append(messages, msg)
-			break
-		}
-		// Swap attacker and defender for the next round
-		attacker, defender = defender, attacker
-
-	}
-	fmt.Printf("➜ %s has %d HP left.\n", defender.curPokemon.Name, defender.curPokemon.HP)
-	fmt.Printf("➜ %s still has %d HP left.\n", attacker.curPokemon.Name, attacker.curPokemon.HP)
-	msg = fmt.Sprintf("➜ %s still has %d HP left.\n", attacker.curPokemon.Name, attacker.curPokemon.HP)
-	messages = append(messages, msg)
-	// announce the turns
-	fmt.Printf("➪ %s has %d turns left.\n", participant1.player.Name, participant1.turn)
-	msg = fmt.Sprintf("➪ %s has %d turns left.\n", participant1.player.Name, participant1.turn)
-	messages = append(messages, msg)
-	fmt.Printf("➪ %s has %d turns left.\n", participant2.player.Name, participant2.turn)
-	msg = fmt.Sprintf("➪ %s has %d turns left.\n", participant2.player.Name, participant2.turn)
-	messages = append(messages, msg)
-	messages = append(messages, "------END BATTLE REPORT-----")
-
-	// msgCh <- strings.Join(messages, "") + "#"
-
-	return winner, loser
 }
-func readPokemonFromClient(conn net.Conn, msg string, pokemonList []*Pokemon) (Pokemon, bool) {
-	// conn.Write([]byte(msg))
-	var chosenPokemon Pokemon
-	msgChOne <- Message{msg: msg, conn: conn}
-	for {
-		reader := bufio.NewReader(conn)
-		pokemonIndex, err := reader.ReadString('\n')
 
-		if err != nil {
-			return Pokemon{}, true
-		}
-		pokemonIndex = strings.TrimSpace(pokemonIndex)
-		index, _ := strconv.Atoi(pokemonIndex)
-
-		// Check if the player wants to surrender
-		if index == -1 {
-			return Pokemon{}, true
-		} else {
-			chosenPokemon = *pokemonList[index-1]
-		}
-		// Check if the chosen Pokemon is deployable
-		if chosenPokemon.Deployable {
-			return chosenPokemon, false
-		} else if !chosenPokemon.Deployable {
-
-			// If the Pokemon is not deployable, send a message to the client and ask for another Pokemon
-			msg := "This Pokemon lost the ability to fight. Please choose another one.\n#"
-			conn.Write([]byte(msg))
-		}
-	}
-
-}
-func getListOfPokemon(pokemonList []*Pokemon) string {
+func getListOfPokemon(pokemonList []*OwnedPokemon) string {
 	var listOfPokemon []string
 	for i, p := range pokemonList {
 		listOfPokemon = append(listOfPokemon, fmt.Sprintf("%d. %s\n", i+1, p.Name))
 	}
-	return strings.Join(listOfPokemon, "") + "#"
-}
-func saveWinner(player *Player) {
-	// Load the existing players from the JSON file
-	file, _ := os.Open(playerLink)
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	existingPlayers := []Player{}
-	_ = decoder.Decode(&existingPlayers)
-
-	// Find the winner in the list and update their data
-	for i, existingPlayer := range existingPlayers {
-		if existingPlayer.Name == player.Name {
-			existingPlayers[i] = *player
-			break
-		}
-	}
-
-	// Save the updated list of players to the JSON file
-	file, _ = os.Create(playerLink)
-	defer file.Close()
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "    ") // Set indent to 4 spaces
-	_ = encoder.Encode(existingPlayers)
-
-	fmt.Printf("Winner %s saved\n", player.Name)
+	return strings.Join(listOfPokemon, "")
 }
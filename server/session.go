@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"pokeGame/protocol"
+)
+
+const (
+	writeTimeout    = 10 * time.Second
+	maxQueuedFrames = 256 // a client this far behind is dropped
+)
+
+// Session owns one client connection and everything written to it. Frames
+// are queued and written by the session's own goroutine, so a slow client
+// only ever holds up itself. Map frames are replaced by newer ones while
+// they wait, and a Viewport drops the Viewport and WorldDelta frames queued
+// before it; every other frame is delivered in order or the session ends.
+type Session struct {
+	conn    *protocol.Conn
+	netConn net.Conn
+
+	mu         sync.Mutex
+	account    string // set once the client logged in
+	queue      []Message
+	pendingMap *Message
+	closing    bool
+	wake       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+var sessions []*Session
+
+// newSession starts the writer of a client that speaks conn over netConn.
+func newSession(netConn net.Conn, conn *protocol.Conn) *Session {
+	s := &Session{
+		conn:    conn,
+		netConn: netConn,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.writeLoop()
+	return s
+}
+
+// register makes the session reachable by broadcasts.
+func (s *Session) register() {
+	mu.Lock()
+	sessions = append(sessions, s)
+	mu.Unlock()
+}
+
+func (s *Session) setAccount(name string) {
+	s.mu.Lock()
+	s.account = name
+	s.mu.Unlock()
+}
+
+// findSession returns a registered session logged in as name.
+func findSession(name string) *Session {
+	mu.Lock()
+	targets := append([]*Session(nil), sessions...)
+	mu.Unlock()
+	for _, s := range targets {
+		s.mu.Lock()
+		account := s.account
+		s.mu.Unlock()
+		if account != "" && playerKey(account) == playerKey(name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Send queues msg without blocking. It is a no-op once the session is
+// closing.
+func (s *Session) Send(msg Message) {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	if msg.typ == protocol.TypeViewport {
+		s.queue = dropViews(s.queue)
+	}
+	if msg.typ == protocol.TypeMapUpdate {
+		s.pendingMap = &msg
+	} else {
+		if len(s.queue) >= maxQueuedFrames {
+			s.mu.Unlock()
+			slog.Warn("session too far behind, disconnecting", "remote", s.netConn.RemoteAddr().String(), "frames", maxQueuedFrames)
+			s.teardown()
+			return
+		}
+		s.queue = append(s.queue, msg)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// dropViews removes the frames a new viewport makes stale.
+func dropViews(queue []Message) []Message {
+	kept := queue[:0]
+	for _, msg := range queue {
+		if msg.typ != protocol.TypeViewport && msg.typ != protocol.TypeWorldDelta {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
+
+// QueueLen returns the number of frames waiting to be written.
+func (s *Session) QueueLen() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.queue)
+	if s.pendingMap != nil {
+		n++
+	}
+	return n
+}
+
+// Close writes whatever is still queued and then closes the connection.
+func (s *Session) Close() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Done is closed once the connection is gone.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) writeLoop() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+		for {
+			s.mu.Lock()
+			batch := s.queue
+			s.queue = nil
+			if s.pendingMap != nil {
+				batch = append(batch, *s.pendingMap)
+				s.pendingMap = nil
+			}
+			closing := s.closing
+			s.mu.Unlock()
+			if len(batch) == 0 {
+				if closing {
+					s.teardown()
+					return
+				}
+				break
+			}
+			for _, msg := range batch {
+				s.netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if err := s.conn.Send(msg.typ, msg.payload); err != nil {
+					slog.Debug("session write failed", "remote", s.netConn.RemoteAddr().String(), "err", err)
+					s.teardown()
+					return
+				}
+			}
+		}
+	}
+}
+
+// teardown closes the connection at once and forgets the session. Readers
+// of the connection see the error and clean up their participant.
+func (s *Session) teardown() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closing = true
+		s.queue = nil
+		s.pendingMap = nil
+		s.mu.Unlock()
+		close(s.done)
+		s.netConn.Close()
+		removeSession(s)
+	})
+}
+
+func removeSession(s *Session) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range sessions {
+		if sessions[i] == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// broadcast queues msg on every registered session.
+func broadcast(msg Message) {
+	if n, ok := msg.payload.(protocol.Notice); ok {
+		slog.Debug("broadcast", "text", strings.TrimSpace(n.Text))
+	}
+	mu.Lock()
+	targets := append([]*Session(nil), sessions...)
+	mu.Unlock()
+	for _, s := range targets {
+		s.Send(msg)
+	}
+}
+
+func notice(text string) Message {
+	return Message{typ: protocol.TypeNotice, payload: protocol.Notice{Text: text}}
+}
+
+func mapUpdate(grid string) Message {
+	return Message{typ: protocol.TypeMapUpdate, payload: protocol.MapUpdate{Map: grid}}
+}
+
+func bye(reason string) Message {
+	return Message{typ: protocol.TypeBye, payload: protocol.Bye{Reason: reason}}
+}
+
+func battlePrompt(text string) Message {
+	return Message{typ: protocol.TypeBattlePrompt, payload: protocol.BattlePrompt{Text: text}}
+}
+
+func battleEvent(text string) Message {
+	return Message{typ: protocol.TypeBattleEvent, payload: protocol.BattleEvent{Text: text}}
+}
+
+func errorMessage(code, text string) Message {
+	return Message{typ: protocol.TypeError, payload: protocol.Error{Code: code, Message: text}}
+}
+
+// relayChat stamps a chat frame with the sender's name and passes it on to
+// every connected client.
+func relayChat(s *Session, name string, env protocol.Envelope) {
+	var chat protocol.Chat
+	if err := env.Decode(&chat); err != nil {
+		s.Send(errorMessage(protocol.ErrBadRequest, err.Error()))
+		return
+	}
+	chat.From = name
+	broadcast(Message{typ: protocol.TypeChat, payload: chat})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// stopping is closed when the server starts shutting down. Battles call
+// themselves off at their next prompt and the matchmaker stops pairing.
+var stopping = make(chan struct{})
+
+// shutdown stops the server in order: no new connections, battles called
+// off, every player saved, clients told and disconnected. Waiting for the
+// battles and the clients is bounded by cfg.ShutdownTimeout; saving is not,
+// since a half-saved player is what a clean stop is for.
+func shutdown(listeners []io.Closer, httpServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, l := range listeners {
+		l.Close()
+	}
+	if httpServer != nil {
+		// WebSockets are hijacked, so this only waits for API requests
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("stop HTTP server", "err", err)
+		}
+	}
+	broadcast(notice("\n🛑 The server is shutting down.\n"))
+
+	close(stopping)
+	if !waitFor(ctx, battlesDone.Wait) {
+		slog.Warn("battles still running at the shutdown deadline, their players are not saved")
+	}
+	if out, err := saveNow(); err != nil {
+		slog.Error("save players", "err", err)
+	} else {
+		slog.Info(out)
+	}
+	if c, ok := playerRepo.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("close player store", "err", err)
+		}
+	}
+
+	mu.Lock()
+	targets := append([]*Session(nil), sessions...)
+	mu.Unlock()
+	for _, s := range targets {
+		s.Send(bye("The server is shutting down."))
+		s.Close()
+	}
+	for _, s := range targets {
+		select {
+		case <-s.Done():
+		case <-ctx.Done():
+			slog.Warn("clients still connected at the shutdown deadline")
+			return
+		}
+	}
+}
+
+// waitFor runs wait and reports whether it returned before ctx was done.
+func waitFor(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
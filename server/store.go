@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlayerRepository is the single entry point for reading and writing player profiles.
+type PlayerRepository interface {
+	Load() error
+	Save(player *Player) error
+	Get(name string) (*Player, bool)
+	List() []*Player
+	Delete(name string) error
+}
+
+// jsonPlayerRepository keeps every player in memory and rewrites players.json
+// through a temp file and rename so readers never see a half-written file.
+type jsonPlayerRepository struct {
+	path    string
+	mu      sync.Mutex
+	players map[string]*Player
+	order   []string
+}
+
+func newJSONPlayerRepository(path string) *jsonPlayerRepository {
+	return &jsonPlayerRepository{
+		path:    path,
+		players: make(map[string]*Player),
+	}
+}
+
+func playerKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (r *jsonPlayerRepository) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	file, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		r.players = make(map[string]*Player)
+		r.order = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open %s: %w", r.path, err)
+	}
+	defer file.Close()
+
+	existingPlayers := []*Player{}
+	if err := json.NewDecoder(file).Decode(&existingPlayers); err != nil {
+		return fmt.Errorf("decode %s: %w", r.path, err)
+	}
+	r.players = make(map[string]*Player)
+	r.order = nil
+	// older files contain one entry per save, the last one is the newest
+	for _, p := range existingPlayers {
+		if p == nil || playerKey(p.Name) == "" {
+			continue
+		}
+		key := playerKey(p.Name)
+		if _, ok := r.players[key]; !ok {
+			r.order = append(r.order, key)
+		}
+		r.players[key] = p
+	}
+	return nil
+}
+
+func (r *jsonPlayerRepository) Save(player *Player) (err error) {
+	defer func(start time.Time) { observeSave(start, err) }(time.Now())
+	key := playerKey(player.Name)
+	if key == "" {
+		return fmt.Errorf("save player: empty name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, existed := r.players[key]
+	r.players[key] = clonePlayer(player)
+	if !existed {
+		r.order = append(r.order, key)
+	}
+	if err := r.flush(); err != nil {
+		// keep memory in line with what is on disk
+		if existed {
+			r.players[key] = previous
+		} else {
+			delete(r.players, key)
+			r.order = r.order[:len(r.order)-1]
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *jsonPlayerRepository) Get(name string) (*Player, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.players[playerKey(name)]
+	if !ok {
+		return nil, false
+	}
+	return clonePlayer(p), true
+}
+
+func (r *jsonPlayerRepository) List() []*Player {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*Player, 0, len(r.order))
+	for _, key := range r.order {
+		list = append(list, clonePlayer(r.players[key]))
+	}
+	return list
+}
+
+func (r *jsonPlayerRepository) Delete(name string) error {
+	key := playerKey(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.players[key]
+	if !ok {
+		return nil
+	}
+	previousOrder := r.order
+	delete(r.players, key)
+	r.order = removeKey(r.order, key)
+	if err := r.flush(); err != nil {
+		r.players[key] = previous
+		r.order = previousOrder
+		return err
+	}
+	return nil
+}
+
+// flush must be called with r.mu held.
+func (r *jsonPlayerRepository) flush() error {
+	list := make([]*Player, 0, len(r.order))
+	for _, key := range r.order {
+		list = append(list, r.players[key])
+	}
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encode players: %w", err)
+	}
+	return writeFileAtomic(r.path, append(data, '\n'))
+}
+
+// writeFileAtomic writes data next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file for %s: %w", path, err)
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("write %s: %w", tmpName, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("sync %s: %w", tmpName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("close %s: %w", tmpName, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("rename %s: %w", tmpName, err)
+	}
+	return nil
+}
+
+func removeKey(keys []string, key string) []string {
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k != key {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// clonePlayer copies the persisted part of a player so callers can keep
+// mutating their copy without touching the repository.
+func clonePlayer(p *Player) *Player {
+	c := &Player{
+		Name:         p.Name,
+		PasswordHash: p.PasswordHash,
+		PokemonList:  make([]*OwnedPokemon, 0, len(p.PokemonList)),
+		Rating:       p.Rating,
+		Wins:         p.Wins,
+		Losses:       p.Losses,
+		Draws:        p.Draws,
+		History:      append([]BattleRecord(nil), p.History...),
+	}
+	for _, pokemon := range p.PokemonList {
+		if pokemon == nil {
+			continue
+		}
+		copied := *pokemon
+		copied.Moves = make([]*MoveSlot, 0, len(pokemon.Moves))
+		for _, slot := range pokemon.Moves {
+			if slot == nil {
+				continue
+			}
+			slotCopy := *slot
+			copied.Moves = append(copied.Moves, &slotCopy)
+		}
+		c.PokemonList = append(c.PokemonList, &copied)
+	}
+	return c
+}
+
+// newPlayerRepository picks the storage backend by name: "json" for the
+// players.json file, "journal" for the write-ahead journal in journalDir.
+func newPlayerRepository(kind, playersPath, journalDir string) (PlayerRepository, error) {
+	switch kind {
+	case "json":
+		return newJSONPlayerRepository(playersPath), nil
+	case "journal":
+		return newJournalPlayerRepository(journalDir, playersPath), nil
+	}
+	return nil, fmt.Errorf("unknown player store %q (want json or journal)", kind)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"pokeGame/protocol"
+)
+
+// Terrain is the ground of a tile. Its value is the letter used for it in
+// map files and in protocol.Viewport.Terrain.
+type Terrain byte
+
+const (
+	Grass     Terrain = protocol.TerrainGrass
+	TallGrass Terrain = protocol.TerrainTallGrass
+	Water     Terrain = protocol.TerrainWater
+	Cave      Terrain = protocol.TerrainCave
+	Mountain  Terrain = protocol.TerrainMountain
+	City      Terrain = protocol.TerrainCity
+	Path      Terrain = protocol.TerrainPath
+	Wall      Terrain = protocol.TerrainWall
+)
+
+// terrainNames are the names of the terrains in biomes.json.
+var terrainNames = map[string]Terrain{
+	"grass":      Grass,
+	"tall_grass": TallGrass,
+	"water":      Water,
+	"cave":       Cave,
+	"mountain":   Mountain,
+	"city":       City,
+	"path":       Path,
+	"wall":       Wall,
+}
+
+func (t Terrain) valid() bool {
+	for _, known := range terrainNames {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// passable reports whether players can walk on t.
+func (t Terrain) passable() bool {
+	return t != Wall
+}
+
+// loadTerrain reads a map file: size lines of size terrain letters, the
+// first line being row 0. Blank lines at the end are ignored.
+func loadTerrain(path string, size int) ([]Terrain, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open map: %w", err)
+	}
+	defer file.Close()
+	var rows []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rows = append(rows, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read map %s: %w", path, err)
+	}
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+	if len(rows) != size {
+		return nil, fmt.Errorf("map %s has %d rows, the world is %dx%d", path, len(rows), size, size)
+	}
+	terrain := make([]Terrain, 0, size*size)
+	for i, row := range rows {
+		if len(row) != size {
+			return nil, fmt.Errorf("map %s: row %d has %d tiles, the world is %dx%d", path, i+1, len(row), size, size)
+		}
+		for j := 0; j < size; j++ {
+			t := Terrain(row[j])
+			if !t.valid() {
+				return nil, fmt.Errorf("map %s: row %d, column %d: unknown terrain %q", path, i+1, j+1, row[j])
+			}
+			terrain = append(terrain, t)
+		}
+	}
+	return terrain, nil
+}
+
+// worldTerrain loads the map file of c, or generates the terrain from its
+// seed.
+func worldTerrain(c WorldConfig) ([]Terrain, error) {
+	if c.Map != "" {
+		return loadTerrain(c.Map, c.Size)
+	}
+	seed := c.Seed
+	for seed == 0 {
+		seed = rand.Int63()
+	}
+	slog.Info("terrain generated", "size", c.Size, "seed", seed)
+	return generateTerrain(c.Size, seed), nil
+}
+
+// rarityWeights say how much more often a common species appears than a
+// rarer one of the same biome.
+var rarityWeights = map[string]float64{
+	"common":    100,
+	"uncommon":  40,
+	"rare":      10,
+	"legendary": 1,
+}
+
+// rarityOf ranks a species by the experience it gives, unless biomes.json
+// names it.
+func rarityOf(species *Pokemon) string {
+	if r, ok := rarityOverrides[strings.ToLower(species.Name)]; ok {
+		return r
+	}
+	switch {
+	case species.Exp < 100:
+		return "common"
+	case species.Exp < 180:
+		return "uncommon"
+	case species.Exp < 270:
+		return "rare"
+	}
+	return "legendary"
+}
+
+// biomes is the content of biomes.json. Every biome weights the Pokemon
+// types found there; "*" is the weight of the types it does not name, 0 if
+// missing. A biome that is not listed has no wild Pokemon. Density is the
+// chance that a Pokemon spawning on a biome's tile stays, 1 if missing, so
+// spawn zones like tall grass fill up faster than the land around them.
+type biomes struct {
+	Rarity  map[string]string             `json:"rarity"` // species name -> common, uncommon, rare or legendary
+	Density map[string]float64            `json:"density"`
+	Biomes  map[string]map[string]float64 `json:"biomes"`
+}
+
+// spawnTable picks the species of the wild Pokemon of one biome.
+type spawnTable struct {
+	density float64
+	species []*Pokemon
+	cumul   []float64 // running total of the weights
+}
+
+// pick returns the species that spawns, or nil when none does this time.
+func (t *spawnTable) pick() *Pokemon {
+	if t == nil || len(t.species) == 0 || rand.Float64() >= t.density {
+		return nil
+	}
+	n := rand.Float64() * t.cumul[len(t.cumul)-1]
+	return t.species[sort.SearchFloat64s(t.cumul, n)]
+}
+
+var (
+	rarityOverrides = map[string]string{}
+	spawnTables     = map[Terrain]*spawnTable{}
+)
+
+// loadBiomes reads the spawn tables. It must run after the Pokedex is
+// loaded. A species weighs the weight of its best-liked type in the biome
+// times that of its rarity.
+func loadBiomes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	var b biomes
+	if err := json.Unmarshal(data, &b); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	overrides := make(map[string]string, len(b.Rarity))
+	for name, rarity := range b.Rarity {
+		if _, ok := rarityWeights[rarity]; !ok {
+			return fmt.Errorf("%s: %s has an unknown rarity %q", path, name, rarity)
+		}
+		overrides[strings.ToLower(name)] = rarity
+	}
+	rarityOverrides = overrides
+
+	for name, density := range b.Density {
+		if _, ok := terrainNames[name]; !ok {
+			return fmt.Errorf("%s: unknown biome %q", path, name)
+		}
+		if density < 0 || density > 1 {
+			return fmt.Errorf("%s: the density of %s must be between 0 and 1", path, name)
+		}
+	}
+	tables := map[Terrain]*spawnTable{}
+	for name, weights := range b.Biomes {
+		terrain, ok := terrainNames[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown biome %q", path, name)
+		}
+		table := &spawnTable{density: 1}
+		if density, ok := b.Density[name]; ok {
+			table.density = density
+		}
+		total := 0.0
+		for i := range pokedex {
+			species := &pokedex[i]
+			weight := 0.0
+			for _, t := range species.Type {
+				w, ok := weights[strings.ToLower(t)]
+				if !ok {
+					w = weights["*"]
+				}
+				if w > weight {
+					weight = w
+				}
+			}
+			if weight <= 0 {
+				continue
+			}
+			total += weight * rarityWeights[rarityOf(species)]
+			table.species = append(table.species, species)
+			table.cumul = append(table.cumul, total)
+		}
+		tables[terrain] = table
+	}
+	spawnTables = tables
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sameTypeBonus is applied when an attack shares a type with its user.
+const sameTypeBonus = 1.5
+
+// TypeChart maps attacking type -> defending type -> damage multiplier.
+// Pairs that are not listed deal normal (1x) damage.
+type TypeChart map[string]map[string]float64
+
+var typeChart = TypeChart{}
+
+func loadTypeChart(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	raw := TypeChart{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	chart := make(TypeChart, len(raw))
+	for attacking, row := range raw {
+		normalized := make(map[string]float64, len(row))
+		for defending, multiplier := range row {
+			if multiplier < 0 {
+				return fmt.Errorf("%s: %s -> %s has a negative multiplier", path, attacking, defending)
+			}
+			normalized[strings.ToLower(defending)] = multiplier
+		}
+		chart[strings.ToLower(attacking)] = normalized
+	}
+	typeChart = chart
+	return nil
+}
+
+// effectiveness multiplies the chart entries for every type of the defender,
+// so a dual-type Pokemon can take 4x or 0.25x damage.
+func (c TypeChart) effectiveness(attackType string, defenderTypes []string) float64 {
+	multiplier := 1.0
+	row := c[strings.ToLower(attackType)]
+	for _, t := range defenderTypes {
+		if m, ok := row[strings.ToLower(t)]; ok {
+			multiplier *= m
+		}
+	}
+	return multiplier
+}
+
+func hasType(types []string, t string) bool {
+	for _, candidate := range types {
+		if strings.EqualFold(candidate, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// effectivenessMessage is the battle log line for a multiplier, empty for
+// neutral hits.
+func effectivenessMessage(multiplier float64, defender string) string {
+	switch {
+	case multiplier == 0:
+		return fmt.Sprintf("It doesn't affect %s...\n", defender)
+	case multiplier > 1:
+		return "It's super effective!\n"
+	case multiplier < 1:
+		return "It's not very effective...\n"
+	}
+	return ""
+}
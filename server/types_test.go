@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestEffectiveness(t *testing.T) {
+	old := typeChart
+	t.Cleanup(func() { typeChart = old })
+	if err := loadTypeChart("Assets/types.json"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		attack   string
+		defender []string
+		want     float64
+	}{
+		{"fire", []string{"grass"}, 2},
+		{"water", []string{"grass"}, 0.5},
+		{"fire", []string{"fire"}, 0.5},
+		{"normal", []string{"normal"}, 1},
+		{"electric", []string{"water", "flying"}, 4},
+		{"grass", []string{"water", "ground"}, 4},
+		{"fire", []string{"water", "rock"}, 0.25},
+		{"fire", []string{"grass", "water"}, 1},
+		{"normal", []string{"ghost"}, 0},
+		{"electric", []string{"ground", "flying"}, 0},
+		{"ground", []string{"flying", "fire"}, 0},
+		{"Fire", []string{"Grass"}, 2},
+		{"fire", nil, 1},
+		{"shadow", []string{"grass"}, 1},
+	}
+	for _, tt := range tests {
+		if got := typeChart.effectiveness(tt.attack, tt.defender); got != tt.want {
+			t.Errorf("%s against %v = %g, want %g", tt.attack, tt.defender, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+
+	"pokeGame/protocol"
+)
+
+// wsFramer carries one envelope per WebSocket text message, so a browser
+// speaks the same protocol as the terminal client without the length
+// prefix.
+type wsFramer struct {
+	conn net.Conn
+	r    *wsutil.Reader
+	mu   sync.Mutex // writes come from the session and from answering pings
+}
+
+func newWSFramer(conn net.Conn, r io.Reader) *wsFramer {
+	f := &wsFramer{conn: conn}
+	f.r = &wsutil.Reader{
+		Source:         r,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		MaxFrameSize:   protocol.MaxFrameSize,
+		OnIntermediate: f.control,
+	}
+	return f
+}
+
+// control answers pings and closes.
+func (f *wsFramer) control(hdr ws.Header, r io.Reader) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return wsutil.ControlHandler{
+		Src:                 r,
+		Dst:                 f.conn,
+		State:               ws.StateServerSide,
+		DisableSrcCiphering: true,
+	}.Handle(hdr)
+}
+
+func (f *wsFramer) ReadEnvelope() (protocol.Envelope, error) {
+	var env protocol.Envelope
+	for {
+		hdr, err := f.r.NextFrame()
+		if err != nil {
+			return env, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := f.control(hdr, f.r); err != nil {
+				return env, err
+			}
+			continue
+		}
+		if hdr.OpCode != ws.OpText {
+			if err := f.r.Discard(); err != nil {
+				return env, err
+			}
+			continue
+		}
+		// a message may be split over several frames
+		data, err := io.ReadAll(io.LimitReader(f.r, protocol.MaxFrameSize+1))
+		if err != nil {
+			return env, err
+		}
+		if len(data) > protocol.MaxFrameSize {
+			return env, fmt.Errorf("message exceeds limit of %d bytes", protocol.MaxFrameSize)
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			return env, fmt.Errorf("decode message: %w", err)
+		}
+		return env, nil
+	}
+}
+
+func (f *wsFramer) WriteEnvelope(env protocol.Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("encode %s message: %w", env.Type, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return wsutil.WriteServerText(f.conn, data)
+}
+
+// serveWebSocket upgrades /ws and hands the connection to the game like a
+// TCP client.
+func serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket", http.StatusForbidden)
+		return
+	}
+	conn, rw, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		slog.Info("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	connCh <- newSession(conn, protocol.NewFramedConn(newWSFramer(conn, rw.Reader)))
+}
+
+// sameOrigin keeps other sites from opening game sessions from a player's
+// browser. Clients that send no Origin are not browsers and are let in.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"pokeGame/protocol"
+)
+
+// chunkSize is the width and height of a chunk of the world in tiles.
+const chunkSize = 32
+
+// World is the catch-mode map. Walking off an edge comes back in on the
+// other side, and X counts rows, Y columns. Tiles are kept in chunks that
+// only exist while something stands on them, so a 1000x1000 world costs no
+// more than what is on it. The terrain under the tiles is kept whole, one
+// byte a tile. Catching players are sent their viewport and
+// then only the changes they can see.
+type World struct {
+	size     int
+	terrain  []Terrain // row by row
+	chunks   map[chunkKey]chunk
+	players  map[string]*Player
+	pokemons []*OwnedPokemon
+	watchers map[string]*Participant // by player name
+	changes  []worldChange           // waiting for publish
+	avatars  int                     // avatars handed out so far
+	lastWave time.Time
+	mux      sync.Mutex
+}
+
+type chunkKey struct {
+	X, Y int
+}
+
+// chunk holds the entities of one chunk by tile.
+type chunk map[Position]any
+
+// worldChange is a change waiting to be published; from is where a moving
+// entity stood before.
+type worldChange struct {
+	op     string
+	entity protocol.Entity
+	from   Position
+}
+
+func newWorld(size int, terrain []Terrain) *World {
+	return &World{
+		size:     size,
+		terrain:  terrain,
+		chunks:   make(map[chunkKey]chunk),
+		players:  make(map[string]*Player),
+		pokemons: []*OwnedPokemon{},
+		watchers: make(map[string]*Participant),
+	}
+}
+
+func chunkOf(pos Position) chunkKey {
+	return chunkKey{pos.X / chunkSize, pos.Y / chunkSize}
+}
+
+// at returns what stands on pos, or nil.
+func (w *World) at(pos Position) any {
+	return w.chunks[chunkOf(pos)][pos]
+}
+
+// put places e on pos, or clears pos when e is nil.
+func (w *World) put(pos Position, e any) {
+	key := chunkOf(pos)
+	c := w.chunks[key]
+	if e == nil {
+		delete(c, pos)
+		if len(c) == 0 {
+			delete(w.chunks, key)
+		}
+		return
+	}
+	if c == nil {
+		c = chunk{}
+		w.chunks[key] = c
+	}
+	c[pos] = e
+}
+
+// terrainAt returns the ground of pos.
+func (w *World) terrainAt(pos Position) Terrain {
+	return w.terrain[pos.X*w.size+pos.Y]
+}
+
+// wrap brings x,y back into the world.
+func (w *World) wrap(x, y int) Position {
+	return Position{(x%w.size + w.size) % w.size, (y%w.size + w.size) % w.size}
+}
+
+// freeTile walks diagonally from pos to the first empty tile that can be
+// walked on, and searches the whole world if the diagonal has none.
+func (w *World) freeTile(pos Position) (Position, bool) {
+	free := func(p Position) bool {
+		return w.at(p) == nil && w.terrainAt(p).passable()
+	}
+	for i := 0; i < w.size; i++ {
+		if free(pos) {
+			return pos, true
+		}
+		pos = w.wrap(pos.X+1, pos.Y+1)
+	}
+	for x := 0; x < w.size; x++ {
+		for y := 0; y < w.size; y++ {
+			if free(Position{x, y}) {
+				return Position{x, y}, true
+			}
+		}
+	}
+	return pos, false
+}
+
+func entityOf(e any) protocol.Entity {
+	switch e := e.(type) {
+	case *Player:
+		return protocol.Entity{Kind: protocol.EntityPlayer, ID: e.Name, Name: e.Name, Avatar: e.avatar, X: e.pos.X, Y: e.pos.Y}
+	case *OwnedPokemon:
+		return protocol.Entity{Kind: protocol.EntityPokemon, ID: e.ID, Name: e.Name, Avatar: e.avatar, Level: e.Level, X: e.pos.X, Y: e.pos.Y}
+	}
+	return protocol.Entity{}
+}
+
+// emit records a change of e for the next publish; from is where e stood
+// before a move.
+func (w *World) emit(op string, e any, from Position) {
+	w.changes = append(w.changes, worldChange{op: op, entity: entityOf(e), from: from})
+}
+
+// viewport returns the tiles a player on pos sees.
+func (w *World) viewport(pos Position) protocol.Viewport {
+	size := min(cfg.World.Viewport, w.size)
+	origin := w.wrap(pos.X-size/2, pos.Y-size/2)
+	return protocol.Viewport{X: origin.X, Y: origin.Y, Width: size, Height: size, WorldSize: w.size}
+}
+
+// snapshot returns the viewport of pos with its terrain and everything on
+// it, looking only at the chunks it overlaps.
+func (w *World) snapshot(pos Position) protocol.Viewport {
+	vp := w.viewport(pos)
+	row := make([]byte, vp.Width)
+	for i := 0; i < vp.Height; i++ {
+		for j := range row {
+			row[j] = byte(w.terrainAt(w.wrap(vp.X+i, vp.Y+j)))
+		}
+		vp.Terrain = append(vp.Terrain, string(row))
+	}
+	var view protocol.View
+	view.Reset(vp)
+	for _, x := range chunkSpan(vp.X, vp.Height, w.size) {
+		for _, y := range chunkSpan(vp.Y, vp.Width, w.size) {
+			for tile, e := range w.chunks[chunkKey{x, y}] {
+				if view.Contains(tile.X, tile.Y) {
+					vp.Entities = append(vp.Entities, entityOf(e))
+				}
+			}
+		}
+	}
+	return vp
+}
+
+// chunkSpan lists the chunk indexes covering n tiles from start.
+func chunkSpan(start, n, size int) []int {
+	var span []int
+	for i := 0; i < n; i++ {
+		c := (start + i) % size / chunkSize
+		if len(span) == 0 || span[len(span)-1] != c {
+			span = append(span, c)
+		}
+	}
+	return span
+}
+
+// sendView sends p its whole viewport. Clients of protocol version 1 get it
+// drawn as a MapUpdate. The caller holds w.mux.
+func (w *World) sendView(p *Participant) {
+	vp := w.snapshot(p.player.pos)
+	session := p.currentSession()
+	if session.conn.Version() < 2 {
+		var view protocol.View
+		view.Reset(vp)
+		session.Send(mapUpdate(view.Render()))
+		return
+	}
+	session.Send(Message{typ: protocol.TypeViewport, payload: vp})
+}
+
+// publish sends the pending changes to every watcher that can see them. A
+// watcher that moved gets its new viewport instead. The caller holds w.mux,
+// so frames are queued in the order the world changed.
+func (w *World) publish() {
+	changes := w.changes
+	w.changes = nil
+	if len(changes) == 0 {
+		return
+	}
+	for name, p := range w.watchers {
+		moved := false
+		for _, c := range changes {
+			if c.op == protocol.ChangeMove && c.entity.Kind == protocol.EntityPlayer && c.entity.ID == name {
+				moved = true
+			}
+		}
+		if moved {
+			w.sendView(p)
+			continue
+		}
+		var view protocol.View
+		view.Reset(w.viewport(p.player.pos))
+		var delta protocol.WorldDelta
+		for _, c := range changes {
+			if view.Contains(c.entity.X, c.entity.Y) || view.Contains(c.from.X, c.from.Y) {
+				delta.Changes = append(delta.Changes, protocol.EntityChange{Op: c.op, Entity: c.entity})
+			}
+		}
+		switch {
+		case len(delta.Changes) == 0:
+		case p.currentSession().conn.Version() < 2:
+			w.sendView(p)
+		default:
+			p.send(Message{typ: protocol.TypeWorldDelta, payload: delta})
+		}
+	}
+}
+
+// watch starts sending p what it sees, beginning with its viewport. It is
+// called again when p resumes or ends an encounter.
+func (w *World) watch(p *Participant) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.players[p.player.Name] == nil {
+		return
+	}
+	w.watchers[p.player.Name] = p
+	w.sendView(p)
+}
+
+func (w *World) addPlayer(name string, x int, y int) *Player {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	playerAvatar := avatarPokeman[w.avatars%len(avatarPokeman)]
+	w.avatars++
+	// Check if there's another player at the initial position
+	pos, _ := w.freeTile(Position{x, y})
+
+	// get pokemonlist from the player repository
+	player, ok := playerRepo.Get(name)
+	if ok {
+		bindRoster(player)
+		// like battle mode, catching starts with a healthy team
+		for _, p := range player.PokemonList {
+			p.heal()
+		}
+	} else {
+		// if player is not in the json file
+		player = &Player{Name: name, PokemonList: []*OwnedPokemon{}}
+	}
+	player.pos = pos
+	player.avatar = playerAvatar
+	w.players[name] = player
+	w.put(pos, player)
+	w.emit(protocol.ChangeSpawn, player, pos)
+	w.publish()
+	return player
+}
+
+// removePlayer takes a player who left out of the world.
+func (w *World) removePlayer(name string) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	player, ok := w.players[name]
+	if !ok {
+		return
+	}
+	if w.at(player.pos) == player {
+		w.put(player.pos, nil)
+	}
+	delete(w.players, name)
+	delete(w.watchers, name)
+	w.emit(protocol.ChangeDespawn, player, player.pos)
+	w.publish()
+}
+
+// movePlayer moves name by dx,dy. Walking into a wild Pokemon starts an
+// encounter instead if the team has room: the Pokemon is reserved for name
+// and returned, and name stops watching the world until the encounter ends.
+// It returns moved, blocked, wall, encounter or team_full.
+func (w *World) movePlayer(name string, dx, dy int) (string, *OwnedPokemon) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	player, ok := w.players[name]
+	if !ok {
+		return "", nil
+	}
+	from := player.pos
+	to := w.wrap(from.X+dx, from.Y+dy)
+	result := "moved"
+	if !w.terrainAt(to).passable() {
+		playerMoves.inc("wall")
+		return "wall", nil
+	}
+	var wild *OwnedPokemon
+	switch p := w.at(to).(type) {
+	case *Player:
+		result = "blocked"
+	case *OwnedPokemon:
+		switch {
+		case len(player.PokemonList) >= cfg.Team.MaxPokemon:
+			result = "team_full"
+		case p.engaged:
+			result = "blocked"
+		default:
+			result = "encounter"
+			p.engaged = true
+			wild = p
+			delete(w.watchers, name)
+		}
+	}
+	playerMoves.inc(result)
+	if result == "moved" {
+		w.put(from, nil)
+		player.pos = to
+		w.put(to, player)
+		w.emit(protocol.ChangeMove, player, from)
+	}
+	w.publish()
+	return result, wild
+}
+
+// capture adds a wild Pokemon caught in an encounter to the team of player.
+// The team changes under w.mux; the save works on a copy so the rest of the
+// world does not wait for the disk.
+func (w *World) capture(player *Player, p *OwnedPokemon) {
+	w.mux.Lock()
+	p.CaughtAt = time.Now()
+	p.engaged = false
+	player.PokemonList = append(player.PokemonList, p)
+	w.removePokemon(p)
+	w.publish()
+	snapshot := clonePlayer(player)
+	w.mux.Unlock()
+
+	broadcast(notice(fmt.Sprintf("%s captured %s!\n", player.Name, p.Name)))
+	audit.Info("capture", "player", player.Name, "pokemon", p.Name, "pokemon_id", p.ID, "level", p.Level, "x", p.pos.X, "y", p.pos.Y)
+	captures.Add(1)
+	if err := savePlayerData(snapshot); err != nil {
+		slog.Error("save player", "player", player.Name, "err", err)
+		broadcast(notice(fmt.Sprintf("Could not save %s's capture, please try again later.\n", player.Name)))
+	}
+}
+
+// savePlayer saves a copy of player taken under w.mux, so the world does not
+// wait for the disk.
+func (w *World) savePlayer(player *Player) {
+	w.mux.Lock()
+	snapshot := clonePlayer(player)
+	w.mux.Unlock()
+	if err := playerRepo.Save(snapshot); err != nil {
+		slog.Error("save player", "player", player.Name, "err", err)
+	}
+}
+
+// release frees a wild Pokemon at the end of an encounter. One that fled or
+// fainted is gone; otherwise it recovers and stays until it despawns.
+func (w *World) release(p *OwnedPokemon, gone bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	p.engaged = false
+	if gone {
+		w.removePokemon(p)
+		w.publish()
+		return
+	}
+	p.heal()
+}
+
+func (w *World) spawnPokemonWave() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.lastWave = time.Now()
+	spawnWaves.Add(1)
+	// Ensure n is greater than 0
+	n := len(pokedex)
+	if n <= 0 {
+		slog.Warn("no Pokemon to spawn")
+		return
+	}
+	var near []Position
+	for _, p := range w.players {
+		near = append(near, p.pos)
+	}
+	for i := 0; i < cfg.World.PokemonPerSpawn; i++ {
+		pos, ok := w.freeTile(w.spawnTile(near))
+		if !ok {
+			continue
+		}
+		// a species that lives on this terrain; towns have none
+		species := spawnTables[w.terrainAt(pos)].pick()
+		if species == nil {
+			continue
+		}
+		pokemon := newPokemonInstance(species, 1+rand.Intn(cfg.World.WildLevelMax))
+		w.placePokemon(pokemon, pos.X, pos.Y)
+	}
+	w.publish()
+}
+
+// spawnTile picks a random tile in the viewport of a random player, or
+// anywhere when nobody is around. In a large world a Pokemon far from
+// everybody would never be seen.
+func (w *World) spawnTile(near []Position) Position {
+	if len(near) == 0 {
+		return Position{rand.Intn(w.size), rand.Intn(w.size)}
+	}
+	vp := w.viewport(near[rand.Intn(len(near))])
+	return w.wrap(vp.X+rand.Intn(vp.Height), vp.Y+rand.Intn(vp.Width))
+}
+
+// placePokemon puts a wild Pokemon on a free tile. The caller holds w.mux
+// and publishes.
+func (w *World) placePokemon(pokemon *OwnedPokemon, x, y int) {
+	pokemon.pos = Position{x, y}
+	pokemon.spawnTime = time.Now()
+	pokemon.avatar = "🐼"
+
+	// Add the Pokemon to the world
+	w.put(pokemon.pos, pokemon)
+	w.pokemons = append(w.pokemons, pokemon)
+	pokemonSpawned.Add(1)
+	w.emit(protocol.ChangeSpawn, pokemon, pokemon.pos)
+}
+
+// spawnPokemonAt places one wild Pokemon of species on tile x,y.
+func (w *World) spawnPokemonAt(species *Pokemon, level, x, y int) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if x < 0 || y < 0 || x >= w.size || y >= w.size {
+		return fmt.Errorf("%d,%d is outside the %dx%d world", x, y, w.size, w.size)
+	}
+	if w.at(Position{x, y}) != nil {
+		return fmt.Errorf("tile %d,%d is taken", x, y)
+	}
+	if !w.terrainAt(Position{x, y}).passable() {
+		return fmt.Errorf("tile %d,%d is a wall", x, y)
+	}
+	w.placePokemon(newPokemonInstance(species, level), x, y)
+	w.publish()
+	return nil
+}
+
+// despawnAll clears every wild Pokemon that is not in an encounter and
+// returns how many there were.
+func (w *World) despawnAll() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	n := 0
+	for _, p := range append([]*OwnedPokemon(nil), w.pokemons...) {
+		if !p.engaged {
+			w.removePokemon(p)
+			n++
+		}
+	}
+	pokemonDespawned.add("admin", uint64(n))
+	w.publish()
+	return n
+}
+
+// removePokemon takes a wild Pokemon off the map, unless it is gone
+// already. The caller holds w.mux and publishes.
+func (w *World) removePokemon(p *OwnedPokemon) {
+	for i, pokemon := range w.pokemons {
+		if pokemon == p {
+			w.pokemons = append(w.pokemons[:i], w.pokemons[i+1:]...)
+			w.put(p.pos, nil)
+			w.emit(protocol.ChangeDespawn, p, p.pos)
+			return
+		}
+	}
+}
+
+func savePlayerData(player *Player) error {
+	if err := playerRepo.Save(player); err != nil {
+		return err
+	}
+	broadcast(notice(fmt.Sprintf("Player %s saved\n", player.Name)))
+	return nil
+}
+
+func (w *World) deSpawnPokemons() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	now := time.Now()
+	// removing shifts w.pokemons, so walk a copy
+	for _, p := range append([]*OwnedPokemon(nil), w.pokemons...) {
+		if !p.engaged && now.Sub(p.spawnTime) >= cfg.World.DespawnAfter-time.Second*2 {
+			w.removePokemon(p)
+			pokemonDespawned.inc("expired")
+		}
+	}
+	w.publish()
+}
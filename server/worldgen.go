@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+)
+
+// generateTerrain builds the terrain of a size x size world from seed. Two
+// noise fields, elevation and moisture, decide the biomes: the lowest land
+// is water, the highest mountain with cliffs (walls) on top and caves where
+// it is wet, and wet grassland grows the tall grass where Pokemon spawn most.
+// Towns are then placed on grass and joined by paths, which bridge water
+// and cut through cliffs so every town can be reached. The same size and
+// seed always give the same map, and like the world it wraps at the edges.
+func generateTerrain(size int, seed int64) []Terrain {
+	elevation := fractalNoise(size, seed, 4)
+	moisture := fractalNoise(size, seed+1, 3)
+	sea := quantile(elevation, 0.15)
+	highland := quantile(elevation, 0.88)
+	cliffs := quantile(elevation, 0.97)
+	wet := quantile(moisture, 0.65)
+	caves := quantile(moisture, 0.8)
+
+	terrain := make([]Terrain, size*size)
+	for i, e := range elevation {
+		switch m := moisture[i]; {
+		case e < sea:
+			terrain[i] = Water
+		case e >= cliffs:
+			terrain[i] = Wall
+		case e >= highland && m >= caves:
+			terrain[i] = Cave
+		case e >= highland:
+			terrain[i] = Mountain
+		case m >= wet:
+			terrain[i] = TallGrass
+		default:
+			terrain[i] = Grass
+		}
+	}
+	r := rand.New(rand.NewSource(seed))
+	towns := placeTowns(terrain, size, r)
+	for i := 1; i < len(towns); i++ {
+		layPath(terrain, size, towns[i], nearestTown(towns[:i], towns[i], size))
+	}
+	return terrain
+}
+
+// fractalNoise returns size*size values in [0,1), row by row, summing
+// octaves of value noise that each have twice the detail and half the
+// weight of the previous one.
+func fractalNoise(size int, seed int64, octaves int) []float64 {
+	field := make([]float64, size*size)
+	weight, total := 1.0, 0.0
+	cells := max(3, size/20) // lattice cells across the first octave
+	for o := 0; o < octaves; o++ {
+		period := min(cells<<o, size)
+		for x := 0; x < size; x++ {
+			for y := 0; y < size; y++ {
+				field[x*size+y] += weight * valueNoise(seed+int64(o), x, y, size, period)
+			}
+		}
+		total += weight
+		weight /= 2
+	}
+	for i := range field {
+		field[i] /= total
+	}
+	return field
+}
+
+// valueNoise smoothly blends random values on a period x period lattice
+// stretched over the world. The lattice wraps, so the noise does too.
+func valueNoise(seed int64, x, y, size, period int) float64 {
+	fx := float64(x) * float64(period) / float64(size)
+	fy := float64(y) * float64(period) / float64(size)
+	x0, y0 := int(fx), int(fy)
+	tx, ty := smoothstep(fx-float64(x0)), smoothstep(fy-float64(y0))
+	x1, y1 := (x0+1)%period, (y0+1)%period
+	top := lerp(latticeValue(seed, x0, y0), latticeValue(seed, x0, y1), ty)
+	bottom := lerp(latticeValue(seed, x1, y0), latticeValue(seed, x1, y1), ty)
+	return lerp(top, bottom, tx)
+}
+
+// latticeValue hashes a lattice point to [0,1) with the splitmix64 finalizer.
+func latticeValue(seed int64, x, y int) float64 {
+	h := uint64(seed) ^ uint64(x)*0x9e3779b97f4a7c15 ^ uint64(y)*0xc2b2ae3d27d4eb4f
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return float64(h>>11) / (1 << 53)
+}
+
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// quantile returns the value below which the share q of values lies.
+func quantile(values []float64, q float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[min(int(q*float64(len(sorted))), len(sorted)-1)]
+}
+
+// placeTowns stamps towns on grass, about one per 40x40 tiles and spread
+// apart, and returns their centers.
+func placeTowns(terrain []Terrain, size int, r *rand.Rand) []Position {
+	want := max(1, size*size/1600)
+	spacing := int(float64(size) / math.Sqrt(float64(want)) / 2)
+	var towns []Position
+	for tries := 0; len(towns) < want && tries < want*50; tries++ {
+		center := Position{r.Intn(size), r.Intn(size)}
+		if t := terrain[center.X*size+center.Y]; t != Grass && t != TallGrass {
+			continue
+		}
+		if len(towns) > 0 && wrappedDistance(center, nearestTown(towns, center, size), size) < spacing {
+			continue
+		}
+		radius := 1 + r.Intn(max(1, min(3, size/10)))
+		for x := -radius; x <= radius; x++ {
+			for y := -radius; y <= radius; y++ {
+				terrain[wrapIndex(center.X+x, center.Y+y, size)] = City
+			}
+		}
+		towns = append(towns, center)
+	}
+	return towns
+}
+
+// layPath draws a path from a to b, first along the column, then along the
+// row, going round the world where that is shorter. Towns are left as they
+// are.
+func layPath(terrain []Terrain, size int, a, b Position) {
+	x, y := a.X, a.Y
+	step := func(from, to int) int {
+		d := ((to-from)%size + size) % size
+		if d == 0 {
+			return 0
+		}
+		if d <= size/2 {
+			return 1
+		}
+		return -1
+	}
+	for {
+		if i := wrapIndex(x, y, size); terrain[i] != City {
+			terrain[i] = Path
+		}
+		if dx := step(x, b.X); dx != 0 {
+			x = (x + dx + size) % size
+		} else if dy := step(y, b.Y); dy != 0 {
+			y = (y + dy + size) % size
+		} else {
+			return
+		}
+	}
+}
+
+func nearestTown(towns []Position, pos Position, size int) Position {
+	nearest := towns[0]
+	for _, t := range towns[1:] {
+		if wrappedDistance(t, pos, size) < wrappedDistance(nearest, pos, size) {
+			nearest = t
+		}
+	}
+	return nearest
+}
+
+// wrappedDistance counts the steps from a to b in a world that wraps.
+func wrappedDistance(a, b Position, size int) int {
+	axis := func(d int) int {
+		d = (d%size + size) % size
+		return min(d, size-d)
+	}
+	return axis(a.X-b.X) + axis(a.Y-b.Y)
+}
+
+func wrapIndex(x, y, size int) int {
+	return (x%size+size)%size*size + (y%size+size)%size
+}
+
+// previewCommand runs "server preview": it prints a generated map as
+// letters, so a seed can be looked at before a season starts with it. The
+// seed and the legend go to stderr, leaving stdout a map file that can be
+// edited and loaded with world.map.
+func previewCommand(args []string) int {
+	fs := flag.NewFlagSet("preview", flag.ContinueOnError)
+	size := fs.Int("size", cfg.World.Size, "width and height of the world")
+	seed := fs.Int64("seed", 0, "seed of the map, random when 0")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server preview [-size n] [-seed n]\n\nPrints the world generated from the seed.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *size < 5 || *size > maxWorldSize {
+		fmt.Fprintf(os.Stderr, "size must be between 5 and %d, got %d\n", maxWorldSize, *size)
+		return 2
+	}
+	for *seed == 0 {
+		*seed = rand.Int63()
+	}
+	terrain := generateTerrain(*size, *seed)
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(os.Stderr, "seed %d, %dx%d\n", *seed, *size, *size)
+	for x := 0; x < *size; x++ {
+		for _, t := range terrain[x**size : (x+1)**size] {
+			out.WriteByte(byte(t))
+		}
+		out.WriteByte('\n')
+	}
+	out.Flush()
+	fmt.Fprintf(os.Stderr, "%c grass  %c tall grass  %c water  %c cave  %c mountain  %c town  %c path  %c wall\n",
+		Grass, TallGrass, Water, Cave, Mountain, City, Path, Wall)
+	return 0
+}
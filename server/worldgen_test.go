@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGenerateTerrainIsDeterministic(t *testing.T) {
+	a := generateTerrain(80, 42)
+	b := generateTerrain(80, 42)
+	if !slices.Equal(a, b) {
+		t.Error("the same seed gave two different maps")
+	}
+	if slices.Equal(a, generateTerrain(80, 43)) {
+		t.Error("two seeds gave the same map")
+	}
+}
+
+// TestGenerateTerrainJoinsTowns walks the town and path tiles from one town
+// and expects to reach every town tile.
+func TestGenerateTerrainJoinsTowns(t *testing.T) {
+	const size = 120
+	for _, seed := range []int64{1, 2, 3, 1234567} {
+		terrain := generateTerrain(size, seed)
+		start, towns := -1, 0
+		for i, tile := range terrain {
+			if tile == City {
+				towns++
+				if start < 0 {
+					start = i
+				}
+			}
+		}
+		if towns == 0 {
+			t.Fatalf("seed %d: no towns", seed)
+		}
+
+		seen := map[int]bool{start: true}
+		queue := []int{start}
+		reached := 0
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			if !terrain[i].passable() {
+				t.Fatalf("seed %d: town or path tile %d,%d is %c", seed, i/size, i%size, terrain[i])
+			}
+			if terrain[i] == City {
+				reached++
+			}
+			x, y := i/size, i%size
+			for _, n := range []int{wrapIndex(x-1, y, size), wrapIndex(x+1, y, size), wrapIndex(x, y-1, size), wrapIndex(x, y+1, size)} {
+				if !seen[n] && (terrain[n] == City || terrain[n] == Path) {
+					seen[n] = true
+					queue = append(queue, n)
+				}
+			}
+		}
+		if reached != towns {
+			t.Errorf("seed %d: paths reach %d of %d town tiles", seed, reached, towns)
+		}
+	}
+}